@@ -1,12 +1,37 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // Entry represents an individual record in the CSV file.
@@ -16,152 +41,7733 @@ type Entry struct {
 	Name                  string
 	Criticality           string
 	RelevantComputerCount int
+	// Notes is a free-text annotation, stored in an optional last column.
+	Notes string `json:",omitempty"`
 }
 
-// ReadCSV reads the CSV file and returns a slice of Entry structs.
-func ReadCSV(filename string) ([]Entry, error) {
+// stdin is shared by every interactive prompt so buffered input is never lost.
+var stdin = bufio.NewReader(os.Stdin)
+
+// QuietMode, set with --quiet, suppresses messages that only confirm that
+// something worked. Prompts, results, warnings and errors are still shown.
+var QuietMode bool
+
+// VerboseMode, set with --verbose, adds debug messages on stderr: the file
+// used, rows read, bytes written and how long it took.
+var VerboseMode bool
+
+// info prints a confirmation message unless QuietMode is set.
+func info(a ...any) {
+	if !QuietMode {
+		fmt.Println(a...)
+	}
+}
+
+// infof is info with a format string.
+func infof(format string, a ...any) {
+	if !QuietMode {
+		fmt.Printf(format, a...)
+	}
+}
+
+// debugf prints a debug message on stderr when VerboseMode is set.
+func debugf(format string, a ...any) {
+	if VerboseMode {
+		fmt.Fprintf(os.Stderr, "debug: "+format+"\n", a...)
+	}
+}
+
+// readLine reads a single line from stdin with surrounding whitespace removed.
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// CSVOptions controls how CSV files are read and written.
+type CSVOptions struct {
+	// Delimiter is the field separator. A zero value means comma.
+	Delimiter rune
+	// NormalizeNames applies NormalizeName to every name read.
+	NormalizeNames bool
+	// Columns lists the canonical field names to write, in order. Nil means
+	// all of FieldNames.
+	Columns []string
+	// SkipHeader leaves out the header record when writing, for output
+	// that is appended to existing CSV data.
+	SkipHeader bool
+}
+
+// ParseDelimiter converts a delimiter flag value such as "," or "tab" into a rune.
+func ParseDelimiter(s string) (rune, error) {
+	switch s {
+	case "", ",":
+		return ',', nil
+	case "tab", "\\t", "\t":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q", s)
+	}
+	return r[0], nil
+}
+
+// AllowedCriticalities lists the accepted Criticality values in their canonical casing.
+var AllowedCriticalities = []string{"Critical", "High", "Important", "Medium", "Moderate", "Low", "Informational"}
+
+// MaxSiteID is the largest SiteID considered valid. It can be changed with
+// the max_site_id config setting.
+var MaxSiteID = 99999
+
+// ValidateSiteIDRange returns an error unless lo <= siteID <= hi.
+func ValidateSiteIDRange(siteID, lo, hi int) error {
+	if siteID < lo || siteID > hi {
+		return fmt.Errorf("SiteID %d is out of range %d-%d", siteID, lo, hi)
+	}
+	return nil
+}
+
+// ValidationError describes a field of an entry that failed validation.
+type ValidationError struct {
+	Row      int // 1-based position of the entry in the dataset
+	FixletID int
+	Field    string
+	Message  string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("row %d (FixletID %d): %s: %s", e.Row, e.FixletID, e.Field, e.Message)
+}
+
+// ValidateCriticality returns an error if c is not one of AllowedCriticalities.
+// The comparison is case-insensitive.
+func ValidateCriticality(c string) error {
+	if _, ok := canonicalCriticality(c); !ok {
+		return fmt.Errorf("invalid criticality %q: must be one of %s", c, strings.Join(AllowedCriticalities, ", "))
+	}
+	return nil
+}
+
+// FieldInfo describes one column of the CSV layout.
+type FieldInfo struct {
+	Name        string
+	Type        string
+	Required    bool
+	Constraints []string
+
+	unique bool               // no two entries may share a value
+	check  func(Entry) string // describes a problem with the value, or ""
+}
+
+// Schema returns the specification of every CSV column, in file order. The
+// constraints reflect the current MaxSiteID and AllowedCriticalities.
+func Schema() []FieldInfo {
+	return []FieldInfo{
+		{
+			Name: "SiteID", Type: "int", Required: true,
+			Constraints: []string{fmt.Sprintf("between 1 and %d", MaxSiteID)},
+			check: func(e Entry) string {
+				if ValidateSiteIDRange(e.SiteID, 1, MaxSiteID) != nil {
+					return fmt.Sprintf("%d is out of range 1-%d", e.SiteID, MaxSiteID)
+				}
+				return ""
+			},
+		},
+		{
+			Name: "FixletID", Type: "int", Required: true,
+			Constraints: []string{"unique"},
+			unique:      true,
+		},
+		{
+			Name: "Name", Type: "string", Required: true,
+			Constraints: []string{"not empty"},
+			check: func(e Entry) string {
+				if strings.TrimSpace(e.Name) == "" {
+					return "is empty"
+				}
+				return ""
+			},
+		},
+		{
+			Name: "Criticality", Type: "string", Required: true,
+			Constraints: []string{"one of " + strings.Join(AllowedCriticalities, ", ") + " (case-insensitive)"},
+			check: func(e Entry) string {
+				if _, ok := canonicalCriticality(e.Criticality); !ok {
+					return fmt.Sprintf("%q is not an allowed criticality", e.Criticality)
+				}
+				return ""
+			},
+		},
+		{
+			Name: "RelevantComputerCount", Type: "int", Required: true,
+			Constraints: []string{"not negative"},
+			check: func(e Entry) string {
+				if e.RelevantComputerCount < 0 {
+					return fmt.Sprintf("%d is negative", e.RelevantComputerCount)
+				}
+				return ""
+			},
+		},
+		{Name: "Notes", Type: "string"},
+	}
+}
+
+// PrintSchema writes fields to w as a table.
+func PrintSchema(fields []FieldInfo, w io.Writer) {
+	rows := make([][]string, len(fields))
+	for i, f := range fields {
+		required := "no"
+		if f.Required {
+			required = "yes"
+		}
+		rows[i] = []string{f.Name, f.Type, required, strings.Join(f.Constraints, "; ")}
+	}
+	writeTable(w, []string{"Field", "Type", "Required", "Constraints"}, rows)
+}
+
+// ValidateEntries checks the whole dataset against Schema and returns one
+// ValidationError per problem found, in row order and, within a row, in
+// column order.
+func ValidateEntries(entries []Entry) []ValidationError {
+	var errs []ValidationError
+	schema := Schema()
+	firstRow := make(map[string]map[string]int)
+	for _, f := range schema {
+		if f.unique {
+			firstRow[f.Name] = make(map[string]int, len(entries))
+		}
+	}
+	for i, e := range entries {
+		row := i + 1
+		for _, f := range schema {
+			if f.unique {
+				value := fieldString(e, f.Name)
+				if first, seen := firstRow[f.Name][value]; seen {
+					errs = append(errs, ValidationError{row, e.FixletID, f.Name, fmt.Sprintf("duplicate of row %d", first)})
+				} else {
+					firstRow[f.Name][value] = row
+				}
+			}
+			if f.check == nil {
+				continue
+			}
+			if msg := f.check(e); msg != "" {
+				errs = append(errs, ValidationError{row, e.FixletID, f.Name, msg})
+			}
+		}
+	}
+	return errs
+}
+
+// SuspiciousEntries returns warnings about values that are allowed but
+// likely to be mistakes: no relevant computers, names with stray whitespace,
+// non-canonical criticality casing and names shared by different FixletIDs.
+func SuspiciousEntries(entries []Entry) []ValidationError {
+	var warnings []ValidationError
+	firstName := make(map[string]int, len(entries))
+	for i, e := range entries {
+		row := i + 1
+		add := func(field, format string, args ...any) {
+			warnings = append(warnings, ValidationError{row, e.FixletID, field, fmt.Sprintf(format, args...)})
+		}
+		if e.RelevantComputerCount == 0 {
+			add("RelevantComputerCount", "no relevant computers")
+		}
+		if e.Name != strings.Join(strings.Fields(e.Name), " ") {
+			add("Name", "has leading, trailing or repeated whitespace")
+		}
+		if canonical, ok := canonicalCriticality(e.Criticality); ok && canonical != e.Criticality {
+			add("Criticality", "%q is not in canonical form %q", e.Criticality, canonical)
+		}
+		key := strings.ToLower(strings.TrimSpace(e.Name))
+		if first, seen := firstName[key]; seen && entries[first-1].FixletID != e.FixletID {
+			add("Name", "same name as row %d (FixletID %d)", first, entries[first-1].FixletID)
+		} else if !seen && key != "" {
+			firstName[key] = row
+		}
+	}
+	return warnings
+}
+
+// PrintValidationErrors writes errs and warnings to w grouped by row, each
+// row headed by its FixletID.
+func PrintValidationErrors(errs, warnings []ValidationError, w io.Writer) {
+	type problem struct {
+		label string
+		ValidationError
+	}
+	var problems []problem
+	for _, e := range errs {
+		problems = append(problems, problem{"error", e})
+	}
+	for _, e := range warnings {
+		problems = append(problems, problem{"warning", e})
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Row < problems[j].Row })
+	for i, p := range problems {
+		if i == 0 || p.Row != problems[i-1].Row {
+			fmt.Fprintf(w, "Row %d (FixletID %d):\n", p.Row, p.FixletID)
+		}
+		fmt.Fprintf(w, "  %s: %s: %s\n", p.label, p.Field, p.Message)
+	}
+}
+
+// canonicalCriticality returns the canonical casing of c if it is allowed.
+func canonicalCriticality(c string) (string, bool) {
+	for _, allowed := range AllowedCriticalities {
+		if strings.EqualFold(allowed, c) {
+			return allowed, true
+		}
+	}
+	return "", false
+}
+
+// RowError describes a CSV row that could not be read cleanly.
+type RowError struct {
+	Line   int      // line number in the file
+	Fields []string // raw fields of the row
+	Err    error
+	File   string // file the row is in; set only when several files are read
+}
+
+func (e RowError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s: line %d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// ErrFileNotFound is returned, wrapping the underlying error, when the CSV
+// file to read does not exist.
+var ErrFileNotFound = errors.New("csv file not found")
+
+// openCSV opens filename for reading, wrapping a missing-file error with
+// ErrFileNotFound.
+func openCSV(filename string) (*os.File, error) {
 	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %w", ErrFileNotFound, err)
+	}
+	return file, err
+}
+
+// ReadCSV reads the CSV file and returns a slice of Entry structs, the time
+// recorded in its last_modified comment and an error for every problematic
+// row. Rows that cannot be parsed are left out of the entries; rows whose
+// Criticality is not allowed are kept, with a ValidationError recorded for
+// them. The time is zero when the file has no valid last_modified comment.
+func ReadCSV(filename string, opts CSVOptions) ([]Entry, time.Time, []RowError, error) {
+	return readCSVFile(context.Background(), filename, opts, nil)
+}
+
+// ReadCSVContext reads the CSV file like ReadCSV but stops once ctx is done,
+// returning the entries read so far and an error that wraps ctx.Err() and
+// says how many rows were processed.
+func ReadCSVContext(ctx context.Context, filename string, opts CSVOptions) ([]Entry, []RowError, error) {
+	entries, _, rowErrors, err := readCSVFile(ctx, filename, opts, nil)
+	return entries, rowErrors, err
+}
+
+// ReadCSVProgress reads the CSV file like ReadCSV, calling progress as the
+// file is read with the number of bytes read so far and the size of the
+// file. For .gz files both count compressed bytes.
+func ReadCSVProgress(filename string, opts CSVOptions, progress func(bytesRead, totalBytes int64)) ([]Entry, []RowError, error) {
+	entries, _, rowErrors, err := readCSVFile(context.Background(), filename, opts, progress)
+	return entries, rowErrors, err
+}
+
+// progressReader passes on reads from r, reporting the running total to
+// progress.
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	total    int64
+	progress func(bytesRead, totalBytes int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	p.progress(p.read, p.total)
+	return n, err
+}
+
+// readCSVFile opens, decompresses if needed and reads the CSV file, reporting
+// progress when it is not nil. The last_modified comment is parsed in the
+// same pass, so the time always belongs to the rows returned with it.
+func readCSVFile(ctx context.Context, filename string, opts CSVOptions, progress func(bytesRead, totalBytes int64)) ([]Entry, time.Time, []RowError, error) {
+	file, err := openCSV(filename)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, nil, err
 	}
 	defer file.Close()
 
-	var entries []Entry
-	reader := csv.NewReader(file)
-	_, _ = reader.Read() // Skip header
-	for {
-		record, err := reader.Read()
+	var r io.Reader = file
+	if progress != nil {
+		info, err := file.Stat()
 		if err != nil {
-			break
+			return nil, time.Time{}, nil, err
 		}
-		siteID, _ := strconv.Atoi(record[0])
-		fixletID, _ := strconv.Atoi(record[1])
-		relevantComputerCount, _ := strconv.Atoi(record[4])
-		entries = append(entries, Entry{siteID, fixletID, record[2], record[3], relevantComputerCount})
+		r = &progressReader{r: file, total: info.Size(), progress: progress}
 	}
-	return entries, nil
+	if isGzipFile(filename) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, time.Time{}, nil, fmt.Errorf("%s is not a valid gzip file: %w", filename, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	r, modified := readPreamble(r)
+	entries, rowErrors, err := readCSVFrom(ctx, r, opts)
+	if err != nil {
+		return entries, time.Time{}, rowErrors, err
+	}
+	return entries, modified, rowErrors, nil
 }
 
-// WriteCSV writes the list of entries to the CSV file.
-func WriteCSV(filename string, entries []Entry) error {
-	file, err := os.Create(filename)
+// progressBar returns a progress callback that draws a bar labelled label on
+// w, redrawing it only when the percentage changes.
+func progressBar(w io.Writer, label string) func(done, total int64) {
+	const width = 40
+	last := -1
+	return func(done, total int64) {
+		percent := 100
+		if total > 0 {
+			percent = int(min(done*100/total, 100))
+		}
+		if percent == last {
+			return
+		}
+		last = percent
+		filled := percent * width / 100
+		fmt.Fprintf(w, "\r%s [%s%s] %3d%%", label, strings.Repeat("#", filled), strings.Repeat(" ", width-filled), percent)
+		if percent == 100 {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// ReadTSV reads a tab-separated file in the same way as ReadCSV.
+func ReadTSV(filename string) ([]Entry, []RowError, error) {
+	entries, _, rowErrors, err := ReadCSV(filename, CSVOptions{Delimiter: '\t'})
+	return entries, rowErrors, err
+}
+
+// WriteTSV writes entries to a tab-separated file in the same way as WriteCSV.
+func WriteTSV(filename string, entries []Entry) error {
+	return WriteCSV(filename, entries, CSVOptions{Delimiter: '\t'})
+}
+
+// looksLikeTSV reports whether the first line of the file contains tabs but
+// no commas, as in files saved by spreadsheets as tab-delimited text.
+func looksLikeTSV(filename string) bool {
+	file, err := os.Open(filename)
 	if err != nil {
-		return err
+		return false
 	}
 	defer file.Close()
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"SiteID", "FixletID", "Name", "Criticality", "RelevantComputerCount"})
-	for _, e := range entries {
-		writer.Write([]string{
-			strconv.Itoa(e.SiteID), strconv.Itoa(e.FixletID), e.Name, e.Criticality, strconv.Itoa(e.RelevantComputerCount),
-		})
+	var r io.Reader = file
+	if isGzipFile(filename) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return false
+		}
+		defer gz.Close()
+		r = gz
 	}
-	writer.Flush()
-	return nil
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if strings.HasPrefix(line, lastModifiedPrefix) {
+		line, err = br.ReadString('\n')
+	}
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.Contains(line, "\t") && !strings.Contains(line, ",")
 }
 
-// ListEntries displays all entries in the CSV file.
-func ListEntries(entries []Entry) {
-	if len(entries) == 0 {
-		fmt.Println("No entries available.")
-		return
+// utf8BOM is the byte order mark some Windows programs put at the start of
+// UTF-8 files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipBOM returns a reader for r without its leading UTF-8 byte order mark,
+// if it has one.
+func skipBOM(r io.Reader) *bufio.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		br.Discard(len(utf8BOM))
 	}
-	for _, e := range entries {
-		fmt.Printf("SiteID: %d, FixletID: %d, Name: %s, Criticality: %s, Computers: %d\n", e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
+	return br
+}
+
+// lastModifiedPrefix starts the comment line WriteCSV puts at the top of
+// the file to record when it was saved.
+const lastModifiedPrefix = "# last_modified: "
+
+// skipPreamble returns a reader for r without its byte order mark and
+// last_modified comment. The comment is replaced by an empty line, which the
+// csv package skips, so line numbers in errors still match the file.
+func skipPreamble(r io.Reader) io.Reader {
+	r, _ = readPreamble(r)
+	return r
+}
+
+// readPreamble is skipPreamble that also returns the time recorded in the
+// last_modified comment. The time is zero when the comment is missing or
+// damaged, which does not stop the rows from loading.
+func readPreamble(r io.Reader) (io.Reader, time.Time) {
+	br := skipBOM(r)
+	if b, err := br.Peek(len(lastModifiedPrefix)); err != nil || string(b) != lastModifiedPrefix {
+		return br, time.Time{}
+	}
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return br, time.Time{}
 	}
+	modified, _ := time.Parse(time.RFC3339, strings.TrimSpace(string(line[len(lastModifiedPrefix):])))
+	return io.MultiReader(strings.NewReader("\n"), br), modified
 }
 
-// QueryEntry searches for entries by name or criticality.
-func QueryEntry(entries []Entry, query string) {
-	query = strings.ToLower(query)
-	for _, e := range entries {
-		if strings.Contains(strings.ToLower(e.Name), query) || strings.Contains(strings.ToLower(e.Criticality), query) {
-			fmt.Printf("SiteID: %d, FixletID: %d, Name: %s, Criticality: %s, Computers: %d\n", e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
-			return
+// writeLastModified writes the last_modified comment for the current time.
+func writeLastModified(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s%s\n", lastModifiedPrefix, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// ReadLastModified returns the time recorded in the last_modified comment of
+// the CSV file, or the zero time if the file has none, for example because
+// it was written by another program.
+func ReadLastModified(filename string) (time.Time, error) {
+	file, err := openCSV(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if isGzipFile(filename) {
+		gz, err := gzip.NewReader(file)
+		if err == io.EOF {
+			return time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s is not a valid gzip file: %w", filename, err)
 		}
+		defer gz.Close()
+		r = gz
+	}
+	line, err := skipBOM(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return time.Time{}, err
 	}
-	fmt.Println("No entries found.")
+	value, ok := strings.CutPrefix(strings.TrimSpace(line), strings.TrimSpace(lastModifiedPrefix))
+	if !ok {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: invalid last_modified comment: %w", filename, err)
+	}
+	return t, nil
 }
 
-// SortEntries sorts entries by the relevant computer count in ascending order.
-func SortEntries(entries []Entry) {
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].RelevantComputerCount < entries[j].RelevantComputerCount
-	})
+// printFileInfo describes the CSV file: its name, how many entries it holds
+// and when it was last saved.
+func printFileInfo(filenames []string, entries []Entry) error {
+	for _, filename := range filenames {
+		modified, err := ReadLastModified(filename)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("File: %s\n", filename)
+		if len(filenames) == 1 {
+			fmt.Printf("Rows: %d\n", len(entries))
+		}
+		if modified.IsZero() {
+			fmt.Println("Last modified: not recorded")
+		} else {
+			fmt.Printf("Last modified: %s\n", modified.Format(time.RFC3339))
+		}
+	}
+	if len(filenames) > 1 {
+		fmt.Printf("Rows: %d\n", len(entries))
+	}
+	return nil
 }
 
-// AddEntry adds a new entry to the list.
-func AddEntry(entries []Entry) ([]Entry, error) {
-	var siteID, fixletID, relevantComputerCount int
-	var name, criticality string
-	fmt.Println("Enter SiteID, FixletID, Name, Criticality, RelevantComputerCount:")
-	if _, err := fmt.Scanf("%d %d %s %s %d", &siteID, &fixletID, &name, &criticality, &relevantComputerCount); err != nil {
-		return entries, err
+// Encodings lists the names accepted by ConvertEncoding.
+var Encodings = []string{"utf-8", "utf-8-bom", "utf-16le", "utf-16be", "windows-1252"}
+
+// windows1252 maps the bytes 0x80-0x9F of Windows-1252 to the characters
+// they stand for. The five unassigned bytes map to the C1 control codes, as
+// in Latin-1; all other bytes have the same value in Unicode.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// DetectEncoding guesses the character encoding of the file from its byte
+// order mark, falling back to "utf-8" if the content is valid UTF-8 and to
+// "windows-1252" otherwise. The result is one of Encodings.
+func DetectEncoding(filename string) (string, error) {
+	content, err := readFileContent(filename)
+	if err != nil {
+		return "", err
 	}
-	entries = append(entries, Entry{siteID, fixletID, name, criticality, relevantComputerCount})
-	return entries, nil
+	return detectEncoding([]byte(content)), nil
 }
 
-// DeleteEntry deletes an entry by FixletID.
-func DeleteEntry(entries []Entry, fixletID int) ([]Entry, bool) {
-	for i, e := range entries {
-		if e.FixletID == fixletID {
-			entries = append(entries[:i], entries[i+1:]...)
-			return entries, true
-		}
+// detectEncoding guesses the encoding of data as described for DetectEncoding.
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		return "utf-8-bom"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	case utf8.Valid(data):
+		return "utf-8"
 	}
-	return entries, false
+	return "windows-1252"
 }
 
-func main() {
-	const filename = "fixlets.csv"
-	// Read the existing CSV data
-	entries, err := ReadCSV(filename)
+// ConvertEncoding rewrites the file src, read as fromEnc, to dst in toEnc.
+// An empty or "auto" fromEnc is replaced by the result of DetectEncoding.
+// The UTF-16 encodings are written with a byte order mark. Characters that
+// Windows-1252 cannot represent make the conversion fail.
+func ConvertEncoding(src, dst, fromEnc, toEnc string) error {
+	content, err := readFileContent(src)
 	if err != nil {
-		fmt.Println("Error reading CSV file:", err)
-		return
+		return err
 	}
-	// Command-line interactions
-	for {
-		var command string
-		fmt.Println("\nChoose an operation: list, query, add, delete, sort, exit")
-		fmt.Scanln(&command)
+	data := []byte(content)
+	if fromEnc == "" || fromEnc == "auto" {
+		fromEnc = detectEncoding(data)
+	}
+	text, err := decodeText(data, strings.ToLower(fromEnc))
+	if err != nil {
+		return err
+	}
+	out, err := encodeText(text, strings.ToLower(toEnc))
+	if err != nil {
+		return err
+	}
+	return writeFileCompressed(dst, func(w io.Writer) error {
+		_, err := w.Write(out)
+		return err
+	})
+}
 
-		switch command {
-		case "list":
-			ListEntries(entries)
-		case "query":
-			var query string
-			fmt.Println("Enter name or criticality to query:")
-			fmt.Scanln(&query)
-			QueryEntry(entries, query)
-		case "sort":
-			SortEntries(entries)
-			ListEntries(entries)
-		case "add":
-			entries, err = AddEntry(entries)
-			if err != nil {
-				fmt.Println("Error adding entry:", err)
+// decodeText converts data in the named encoding to a UTF-8 string, dropping
+// any byte order mark.
+func decodeText(data []byte, enc string) (string, error) {
+	switch enc {
+	case "utf-8", "utf-8-bom":
+		data = bytes.TrimPrefix(data, utf8BOM)
+		if !utf8.Valid(data) {
+			return "", errors.New("the file is not valid UTF-8")
+		}
+		return string(data), nil
+	case "utf-16le", "utf-16be":
+		if len(data)%2 != 0 {
+			return "", fmt.Errorf("the file has an odd number of bytes for %s", enc)
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if enc == "utf-16le" {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
 			} else {
-				WriteCSV(filename, entries)
-				fmt.Println("Entry added.")
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
 			}
-		case "delete":
-			var fixletID int
-			fmt.Println("Enter FixletID to delete:")
-			fmt.Scanln(&fixletID)
-			entries, found := DeleteEntry(entries, fixletID)
-			if found {
-				WriteCSV(filename, entries)
-				fmt.Println("Entry deleted.")
+		}
+		if len(units) > 0 && units[0] == 0xFEFF {
+			units = units[1:]
+		}
+		return string(utf16.Decode(units)), nil
+	case "windows-1252":
+		var b strings.Builder
+		for _, c := range data {
+			if c >= 0x80 && c < 0xA0 {
+				b.WriteRune(windows1252[c-0x80])
 			} else {
-				fmt.Println("Entry not found.")
+				b.WriteRune(rune(c))
 			}
-		case "exit":
-			fmt.Println("Exiting program.")
-			return
-		default:
-			fmt.Println("Invalid command.")
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("unknown encoding %q (expected one of %s)", enc, strings.Join(Encodings, ", "))
+}
+
+// encodeText converts text to the named encoding.
+func encodeText(text, enc string) ([]byte, error) {
+	switch enc {
+	case "utf-8":
+		return []byte(text), nil
+	case "utf-8-bom":
+		return append(slices.Clone(utf8BOM), text...), nil
+	case "utf-16le", "utf-16be":
+		units := append([]uint16{0xFEFF}, utf16.Encode([]rune(text))...)
+		out := make([]byte, 0, 2*len(units))
+		for _, u := range units {
+			if enc == "utf-16le" {
+				out = append(out, byte(u), byte(u>>8))
+			} else {
+				out = append(out, byte(u>>8), byte(u))
+			}
+		}
+		return out, nil
+	case "windows-1252":
+		out := make([]byte, 0, len(text))
+		for i, r := range text {
+			switch j := slices.Index(windows1252[:], r); {
+			case j >= 0:
+				out = append(out, byte(0x80+j))
+			case r < 0x100 && (r < 0x80 || r >= 0xA0):
+				out = append(out, byte(r))
+			default:
+				return nil, fmt.Errorf("%q at byte %d cannot be represented in windows-1252", r, i)
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q (expected one of %s)", enc, strings.Join(Encodings, ", "))
+}
+
+// ReadCSVMulti reads every file with ReadCSV and concatenates their entries
+// in order. Each file has its own header, so files with different column
+// orders can be combined. The RowErrors name the file their row is in.
+func ReadCSVMulti(filenames []string, opts CSVOptions) ([]Entry, []RowError, error) {
+	var entries []Entry
+	var rowErrors []RowError
+	for _, filename := range filenames {
+		more, _, errs, err := ReadCSV(filename, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, more...)
+		for _, rowErr := range errs {
+			rowErr.File = filename
+			rowErrors = append(rowErrors, rowErr)
+		}
+	}
+	return entries, rowErrors, nil
+}
+
+// ReadCSVWithRetry reads the CSV file like ReadCSV, retrying up to maxRetries
+// times if opening or reading it fails with a transient error. The wait
+// before the first retry is backoff and doubles with every retry.
+func ReadCSVWithRetry(filename string, opts CSVOptions, maxRetries int, backoff time.Duration) ([]Entry, []RowError, error) {
+	var entries []Entry
+	var rowErrors []RowError
+	err := retry(maxRetries, backoff, func() error {
+		var err error
+		entries, _, rowErrors, err = ReadCSV(filename, opts)
+		return err
+	})
+	return entries, rowErrors, err
+}
+
+// WriteCSVWithRetry writes the CSV file like WriteCSV, retrying in the same
+// way as ReadCSVWithRetry.
+func WriteCSVWithRetry(filename string, entries []Entry, opts CSVOptions, maxRetries int, backoff time.Duration) error {
+	return retry(maxRetries, backoff, func() error {
+		return WriteCSV(filename, entries, opts)
+	})
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// transient, or has been retried maxRetries times, sleeping between attempts
+// for backoff, then twice as long, and so on.
+func retry(maxRetries int, backoff time.Duration, fn func() error) error {
+	err := fn()
+	for i := 0; i < maxRetries && isTransient(err); i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
+
+// isTransient reports whether err is worth retrying: something in its chain
+// reports itself as temporary, or it is a reset network connection.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) && temp.Temporary() {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection reset")
+}
+
+// watchInterval is how often WatchCSV checks the file for changes.
+const watchInterval = time.Second
+
+// WatchCSV polls the CSV file and, whenever its modification time or size
+// changes, reads it again and calls onChange with the new entries. It blocks
+// until the file can no longer be checked or read, and returns that error.
+func WatchCSV(filename string, opts CSVOptions, onChange func([]Entry)) error {
+	return WatchCSVMulti([]string{filename}, opts, onChange)
+}
+
+// WatchCSVMulti watches several files like WatchCSV and, whenever any of
+// them changes, reads them all again with ReadCSVMulti.
+func WatchCSVMulti(filenames []string, opts CSVOptions, onChange func([]Entry)) error {
+	type state struct {
+		modTime time.Time
+		size    int64
+	}
+	check := func() ([]state, error) {
+		states := make([]state, len(filenames))
+		for i, filename := range filenames {
+			info, err := os.Stat(filename)
+			if err != nil {
+				return nil, err
+			}
+			states[i] = state{info.ModTime(), info.Size()}
+		}
+		return states, nil
+	}
+	last, err := check()
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(watchInterval)
+		states, err := check()
+		if err != nil {
+			return err
+		}
+		if slices.Equal(states, last) {
+			continue
+		}
+		last = states
+		entries, _, err := ReadCSVMulti(filenames, opts)
+		if err != nil {
+			return err
+		}
+		onChange(entries)
+	}
+}
+
+// ReadCSVFrom reads CSV data from r in the same way as ReadCSV.
+func ReadCSVFrom(r io.Reader, opts CSVOptions) ([]Entry, []RowError, error) {
+	return readCSVFrom(context.Background(), r, opts)
+}
+
+// readCSVFrom reads CSV data from r until it ends or ctx is done.
+func readCSVFrom(ctx context.Context, r io.Reader, opts CSVOptions) ([]Entry, []RowError, error) {
+	var entries []Entry
+	var rowErrors []RowError
+	err := streamCSV(ctx, r, opts, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}, func(rowErr RowError) {
+		rowErrors = append(rowErrors, rowErr)
+	})
+	return entries, rowErrors, err
+}
+
+// ErrStop can be returned by a StreamCSV handler to end the stream early
+// without an error.
+var ErrStop = errors.New("stop streaming")
+
+// StreamCSV reads the CSV file one record at a time, calling handler for
+// each entry instead of loading the whole file into memory. Files with a .gz
+// extension are decompressed. Problematic rows are passed to rowError, if it
+// is not nil, as they are found; rows that cannot be parsed are then skipped,
+// while rows that only fail validation still reach handler, as with ReadCSV.
+// The returned error is only about the stream itself.
+func StreamCSV(filename string, opts CSVOptions, handler func(Entry) error, rowError func(RowError)) error {
+	return StreamCSVContext(context.Background(), filename, opts, handler, rowError)
+}
+
+// StreamCSVContext streams the CSV file like StreamCSV but stops once ctx is
+// done, returning an error that wraps ctx.Err() and says how many rows were
+// processed.
+func StreamCSVContext(ctx context.Context, filename string, opts CSVOptions, handler func(Entry) error, rowError func(RowError)) error {
+	file, err := openCSV(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if isGzipFile(filename) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s is not a valid gzip file: %w", filename, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return streamCSV(ctx, r, opts, handler, rowError)
+}
+
+// StreamCSVFrom streams CSV data from r in the same way as StreamCSV.
+func StreamCSVFrom(r io.Reader, opts CSVOptions, handler func(Entry) error, rowError func(RowError)) error {
+	return streamCSV(context.Background(), r, opts, handler, rowError)
+}
+
+// streamCSV parses CSV data from r, passing every entry to handler and every
+// problematic row to rowError, which may be nil. Rows whose Criticality is
+// not allowed or whose SiteID is out of range are passed to both. ctx is
+// checked before every row.
+func streamCSV(ctx context.Context, r io.Reader, opts CSVOptions, handler func(Entry) error, rowError func(RowError)) error {
+	if rowError == nil {
+		rowError = func(RowError) {}
+	}
+	reader := csv.NewReader(skipPreamble(r))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	// Columns are matched by header name, so files written with a different
+	// column order read back correctly. Unrecognised headers are positional.
+	order := headerOrder(header)
+	ordered := make([]string, len(allFields))
+	for row, processed := 1, 0; ; processed++ {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("canceled after %d rows: %w", processed, err)
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return err
+			}
+			rowError(RowError{Line: parseErr.StartLine, Fields: slices.Clone(record), Err: parseErr.Err})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if order != nil && len(record) == len(order) {
+			for i, j := range order {
+				ordered[j] = record[i]
+			}
+			record = ordered[:len(order)]
+		}
+		entry, err := parseRecord(record)
+		if err != nil {
+			rowError(RowError{Line: line, Fields: slices.Clone(record), Err: err})
+			continue
+		}
+		if opts.NormalizeNames {
+			entry.Name = NormalizeName(entry.Name)
+		}
+		if canonical, ok := canonicalCriticality(entry.Criticality); ok {
+			entry.Criticality = canonical
+		} else {
+			rowError(RowError{Line: line, Fields: slices.Clone(record), Err: ValidationError{row, entry.FixletID, "Criticality", fmt.Sprintf("%q is not an allowed criticality", entry.Criticality)}})
+		}
+		if ValidateSiteIDRange(entry.SiteID, 1, MaxSiteID) != nil {
+			rowError(RowError{Line: line, Fields: slices.Clone(record), Err: ValidationError{row, entry.FixletID, "SiteID", fmt.Sprintf("%d is out of range 1-%d", entry.SiteID, MaxSiteID)}})
+		}
+		if err := handler(entry); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+		row++
+	}
+}
+
+// StreamFilter streams the CSV file and writes the entries matching pred to
+// w as CSV, holding only one record in memory at a time. Problematic rows are
+// passed to rowError as with StreamCSV. It returns the number of entries
+// written.
+func StreamFilter(filename string, opts CSVOptions, pred func(Entry) bool, rowError func(RowError), w io.Writer) (int, error) {
+	return streamFilter(func(handler func(Entry) error) error {
+		return StreamCSV(filename, opts, handler, rowError)
+	}, opts, pred, w)
+}
+
+// streamFilter writes the entries produced by stream that match pred to w.
+func streamFilter(stream func(handler func(Entry) error) error, opts CSVOptions, pred func(Entry) bool, w io.Writer) (int, error) {
+	writer := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		writer.Comma = opts.Delimiter
+	}
+	columns := opts.Columns
+	if columns == nil {
+		columns = FieldNames
+	}
+	if !opts.SkipHeader {
+		writer.Write(columns)
+	}
+	matched := 0
+	err := stream(func(e Entry) error {
+		if !pred(e) {
+			return nil
+		}
+		matched++
+		return writer.Write(entryColumns(e, columns))
+	})
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	return matched, err
+}
+
+// RepairCSV rewrites the CSV file without the damage that stops rows from
+// loading. Rows with too few or too many fields, or that cannot be parsed,
+// are dropped with a warning on stderr. Empty fields are filled with
+// defaults: "" for Name, "Unknown" for Criticality and 0 for numbers. It
+// returns the number of rows that were filled in.
+func RepairCSV(filename string, opts CSVOptions) (int, error) {
+	var repaired int
+	err := withLock(filename, func() error {
+		entries, columns, n, err := repairEntries(filename, opts)
+		if err != nil {
+			return err
+		}
+		repaired = n
+		opts.Columns = columns
+		return WriteCSV(filename, entries, opts)
+	})
+	return repaired, err
+}
+
+// repairEntries reads the entries RepairCSV would write, without writing
+// them. It also returns the columns of the file's header when it names every
+// field, so the repaired file can keep their order.
+func repairEntries(filename string, opts CSVOptions) ([]Entry, []string, int, error) {
+	file, err := openCSV(filename)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	reader := csv.NewReader(skipPreamble(file))
+	reader.FieldsPerRecord = -1
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+	header, err := reader.Read()
+	if err != nil {
+		file.Close()
+		if err == io.EOF {
+			return nil, opts.Columns, 0, nil
+		}
+		return nil, nil, 0, err
+	}
+	columns := opts.Columns
+	order := headerOrder(header)
+	if order != nil {
+		columns = make([]string, len(order))
+		for i, j := range order {
+			columns[i] = allFields[j]
+		}
+	}
+	var entries []Entry
+	repaired := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				file.Close()
+				return nil, nil, 0, err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: dropping line %d: %v\n", parseErr.StartLine, parseErr.Err)
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(FieldNames) && len(record) != len(allFields) {
+			fmt.Fprintf(os.Stderr, "Warning: dropping line %d: expected %d fields, got %d\n", line, len(FieldNames), len(record))
+			continue
+		}
+		if order != nil {
+			ordered := make([]string, len(record))
+			for i, j := range order {
+				ordered[j] = record[i]
+			}
+			record = ordered
+		}
+		filled := false
+		for i, field := range FieldNames {
+			if strings.TrimSpace(record[i]) != "" {
+				continue
+			}
+			switch {
+			case isNumericField(field):
+				record[i] = "0"
+			case field == "Criticality":
+				record[i] = "Unknown"
+			}
+			filled = true
+		}
+		entry, err := parseRecord(record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: dropping line %d: %v\n", line, err)
+			continue
+		}
+		entries = append(entries, entry)
+		if filled {
+			repaired++
+		}
+	}
+	file.Close()
+	return entries, columns, repaired, nil
+}
+
+// parseRecord converts the fields of a CSV row into an Entry. The Notes
+// column is optional, so files written before it existed still load.
+func parseRecord(record []string) (Entry, error) {
+	if len(record) != len(FieldNames) && len(record) != len(allFields) {
+		return Entry{}, fmt.Errorf("expected %d fields, got %d", len(FieldNames), len(record))
+	}
+	var notes string
+	if len(record) == len(allFields) {
+		notes = record[5]
+	}
+	siteID, err := strconv.Atoi(record[0])
+	if err != nil {
+		return Entry{}, fmt.Errorf("SiteID: %w", err)
+	}
+	fixletID, err := strconv.Atoi(record[1])
+	if err != nil {
+		return Entry{}, fmt.Errorf("FixletID: %w", err)
+	}
+	relevantComputerCount, err := strconv.Atoi(record[4])
+	if err != nil {
+		return Entry{}, fmt.Errorf("RelevantComputerCount: %w", err)
+	}
+	return Entry{siteID, fixletID, record[2], record[3], relevantComputerCount, notes}, nil
+}
+
+// WriteCSV writes the list of entries to the CSV file. Files with a .gz
+// extension are gzip-compressed. The data is written to a temporary file next
+// to the target and renamed into place, so a failed write never leaves a
+// truncated CSV behind. The first line is a last_modified comment with the
+// time of the write.
+func WriteCSV(filename string, entries []Entry, opts CSVOptions) error {
+	if isGzipFile(filename) {
+		return WriteCSVGzip(filename, entries, opts)
+	}
+	return withLock(filename, func() error {
+		return writeFileAtomic(filename, func(w io.Writer) error {
+			if err := writeLastModified(w); err != nil {
+				return err
+			}
+			return writeCSVRecords(w, entries, opts)
+		})
+	})
+}
+
+// WriteCSVTo writes the list of entries as CSV data to w.
+func WriteCSVTo(w io.Writer, entries []Entry, opts CSVOptions) error {
+	return writeCSVRecords(w, entries, opts)
+}
+
+// WriteCSVGzip writes the list of entries to a gzip-compressed CSV file.
+func WriteCSVGzip(filename string, entries []Entry, opts CSVOptions) error {
+	return withLock(filename, func() error {
+		return writeFileAtomic(filename, func(w io.Writer) error {
+			gz := gzip.NewWriter(w)
+			err := writeLastModified(gz)
+			if err == nil {
+				err = writeCSVRecords(gz, entries, opts)
+			}
+			if err != nil {
+				gz.Close()
+				return err
+			}
+			return gz.Close()
+		})
+	})
+}
+
+// AppendCSV adds entries to the end of the CSV file without reading or
+// rewriting its existing rows. A missing or empty file gets a last_modified
+// comment and a header first; otherwise the time in the comment is updated
+// in place. Files without the comment, and .gz files, whose comment is
+// compressed, are instead rewritten with the rows added. Rows follow the
+// column order of the existing header when it names every field. Since
+// existing rows are not parsed, FixletIDs already in the file are not
+// detected as duplicates. An existing header without a Notes column is an
+// error if any entry has notes.
+func AppendCSV(filename string, entries []Entry, opts CSVOptions) error {
+	return withLock(filename, func() error {
+		return appendCSV(filename, entries, opts)
+	})
+}
+
+// appendCSV does the work of AppendCSV while the lock is held.
+func appendCSV(filename string, entries []Entry, opts CSVOptions) error {
+	header, err := readCSVHeader(filename, opts)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if headerOrder(header) != nil {
+		opts.Columns = make([]string, len(header))
+		for i, h := range header {
+			opts.Columns[i], _ = CanonicalField(strings.TrimSpace(h))
+		}
+	}
+	hasNotes := slices.ContainsFunc(entries, func(e Entry) bool { return e.Notes != "" })
+	if header != nil && hasNotes && !slices.Contains(opts.Columns, "Notes") {
+		return fmt.Errorf("%s has no Notes column; rewrite it to add entries with notes", filename)
+	}
+	opts.SkipHeader = header != nil
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	var stamp bytes.Buffer
+	if err := writeLastModified(&stamp); err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() > 0 {
+		// The comment WriteCSV writes always has the same length, so the
+		// new time fits over the old one.
+		old := make([]byte, stamp.Len())
+		if _, err := file.ReadAt(old, 0); err != nil || isGzipFile(filename) || !isLastModifiedLine(old) {
+			file.Close()
+			return appendByRewrite(filename, entries, opts)
+		}
+		if _, err := file.WriteAt(stamp.Bytes(), 0); err != nil {
+			file.Close()
+			return err
+		}
+		stamp.Reset()
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return err
+	}
+	var w io.Writer = file
+	var gz *gzip.Writer
+	if isGzipFile(filename) {
+		gz = gzip.NewWriter(file)
+		w = gz
+	}
+	_, err = w.Write(stamp.Bytes())
+	if err == nil {
+		err = writeCSVRecords(w, entries, opts)
+	}
+	if gz != nil {
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// isLastModifiedLine reports whether line is a whole last_modified comment
+// as written by writeLastModified, including its newline.
+func isLastModifiedLine(line []byte) bool {
+	value, ok := bytes.CutPrefix(line, []byte(lastModifiedPrefix))
+	if !ok || !bytes.HasSuffix(value, []byte("\n")) {
+		return false
+	}
+	_, err := time.Parse(time.RFC3339, string(bytes.TrimSuffix(value, []byte("\n"))))
+	return err == nil
+}
+
+// appendByRewrite adds entries to the end of the CSV file by rewriting it
+// with a new last_modified comment, for files whose comment cannot be
+// updated in place.
+func appendByRewrite(filename string, entries []Entry, opts CSVOptions) error {
+	content, err := readFileContent(filename)
+	if err != nil {
+		return err
+	}
+	content = stripLastModified(strings.TrimPrefix(content, string(utf8BOM)))
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return writeFileCompressed(filename, func(w io.Writer) error {
+		if err := writeLastModified(w); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			return err
+		}
+		return writeCSVRecords(w, entries, opts)
+	})
+}
+
+// stripLastModified returns content without its leading last_modified
+// comment, if it has one.
+func stripLastModified(content string) string {
+	if !strings.HasPrefix(content, lastModifiedPrefix) {
+		return content
+	}
+	_, rest, _ := strings.Cut(content, "\n")
+	return rest
+}
+
+// CompactCSV copies the CSV file src to dst without its blank lines and
+// records whose fields are all whitespace, trimming the whitespace around
+// every remaining field. It returns the number of blank rows removed. src and
+// dst may be the same file.
+func CompactCSV(src, dst string, opts CSVOptions) (int, error) {
+	var removed int
+	err := withLock(dst, func() error {
+		content, err := readFileContent(src)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %w", ErrFileNotFound, err)
+		}
+		if err != nil {
+			return err
+		}
+		return writeFileCompressed(dst, func(w io.Writer) error {
+			removed, err = compactCSV(content, w, opts)
+			return err
+		})
+	})
+	return removed, err
+}
+
+// compactEntries reads the entries CompactCSV would write to dst, without
+// writing them, and the number of blank rows it would remove.
+func compactEntries(src string, opts CSVOptions) ([]Entry, []RowError, int, error) {
+	content, err := readFileContent(src)
+	if os.IsNotExist(err) {
+		return nil, nil, 0, fmt.Errorf("%w: %w", ErrFileNotFound, err)
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var buf bytes.Buffer
+	removed, err := compactCSV(content, &buf, opts)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	entries, rowErrors, err := ReadCSVFrom(&buf, opts)
+	return entries, rowErrors, removed, err
+}
+
+// writeFileCompressed calls writeFileAtomic, gzip-compressing what write
+// produces when filename has a .gz extension.
+func writeFileCompressed(filename string, write func(io.Writer) error) error {
+	if !isGzipFile(filename) {
+		return writeFileAtomic(filename, write)
+	}
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if err := write(gz); err != nil {
+			gz.Close()
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// compactCSV writes the compacted form of the CSV data in content to w, as
+// described for CompactCSV.
+func compactCSV(content string, w io.Writer, opts CSVOptions) (int, error) {
+	content = strings.TrimPrefix(content, string(utf8BOM))
+	if strings.HasPrefix(content, lastModifiedPrefix) {
+		line, rest, _ := strings.Cut(content, "\n")
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return 0, err
+		}
+		content = rest
+	}
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	writer := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+		writer.Comma = opts.Delimiter
+	}
+	// The csv package skips empty lines silently, so they are counted as
+	// the lines not covered by any record.
+	lines := strings.Count(content, "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		lines++
+	}
+	covered, removed := 0, 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		first, _ := reader.FieldPos(0)
+		last, _ := reader.FieldPos(len(record) - 1)
+		covered += last + strings.Count(record[len(record)-1], "\n") - first + 1
+		blank := true
+		for i, field := range record {
+			record[i] = strings.TrimSpace(field)
+			if record[i] != "" {
+				blank = false
+			}
+		}
+		if blank {
+			removed++
+			continue
+		}
+		if err := writer.Write(record); err != nil {
+			return 0, err
+		}
+	}
+	writer.Flush()
+	return removed + lines - covered, writer.Error()
+}
+
+// LockTimeout is how long LockFile waits for another process to release a
+// lock. It can be changed with the lock_timeout config setting or the
+// --lock-timeout flag.
+var LockTimeout = 5 * time.Second
+
+// lockRetryInterval is how often LockFile retries a lock held elsewhere.
+const lockRetryInterval = 50 * time.Millisecond
+
+// ErrLockTimeout is returned when a lock could not be acquired within
+// LockTimeout because another process holds it.
+var ErrLockTimeout = errors.New("timed out waiting for file lock")
+
+// FileLock is an exclusive advisory lock on a file, held until Unlock is
+// called. Other instances of this program respect it; other programs may not.
+type FileLock struct {
+	path string // the lock file, also the key in heldLocks
+}
+
+// heldLock is a lock file this process holds, with the number of FileLocks
+// that share it.
+type heldLock struct {
+	file  *os.File
+	count int
+}
+
+// heldLocks holds the lock files this process has locked, so that a save
+// made while a read-modify-write holds the lock does not wait for it.
+var (
+	heldLocksMu sync.Mutex
+	heldLocks   = make(map[string]*heldLock)
+)
+
+// LockFile takes an exclusive lock on filename, waiting up to LockTimeout
+// for another process to release it. Since CSV files are replaced by rename
+// when saved, the lock is held on a companion file, filename+".lock", which
+// is removed when the lock is released. A process may lock a file it already
+// holds; the file stays locked until every FileLock on it is released.
+func LockFile(filename string) (*FileLock, error) {
+	path := filename + ".lock"
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	heldLocksMu.Lock()
+	if held := heldLocks[path]; held != nil {
+		held.count++
+		heldLocksMu.Unlock()
+		return &FileLock{path}, nil
+	}
+	heldLocksMu.Unlock()
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("locking %s: %w", filename, err)
+		}
+		// The previous holder may have removed the file while we waited
+		// for it; a lock on a file no longer at path locks nothing.
+		if ok && isLockFile(file, path) {
+			heldLocksMu.Lock()
+			heldLocks[path] = &heldLock{file, 1}
+			heldLocksMu.Unlock()
+			return &FileLock{path}, nil
+		}
+		if ok {
+			unlock(file)
+			file.Close()
+			continue
+		}
+		file.Close()
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s: %w (another process is writing it)", filename, ErrLockTimeout)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// isLockFile reports whether file is still the file at path.
+func isLockFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// Unlock releases the lock, removing the lock file once the process holds
+// no other lock on it.
+func (l *FileLock) Unlock() error {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	held := heldLocks[l.path]
+	if held == nil {
+		return errors.New("file lock already released")
+	}
+	if held.count--; held.count > 0 {
+		return nil
+	}
+	delete(heldLocks, l.path)
+	// The file is removed while still locked, so that a process waiting
+	// for it sees it is gone once it gets the lock. Windows cannot remove
+	// an open file, so there it is removed afterwards, unless another
+	// process waiting for the lock still has it open.
+	var err error
+	if runtime.GOOS != "windows" {
+		err = os.Remove(l.path)
+	}
+	if uerr := unlock(held.file); err == nil {
+		err = uerr
+	}
+	if cerr := held.file.Close(); err == nil {
+		err = cerr
+	}
+	if runtime.GOOS == "windows" {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+// withLock calls fn while holding the lock on filename.
+func withLock(filename string, fn func() error) error {
+	lock, err := LockFile(filename)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if uerr := lock.Unlock(); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// readCSVHeader returns the first record of the CSV file, or nil if the file
+// is empty.
+func readCSVHeader(filename string, opts CSVOptions) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if isGzipFile(filename) {
+		gz, err := gzip.NewReader(file)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid gzip file: %w", filename, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	reader := csv.NewReader(skipPreamble(r))
+	reader.FieldsPerRecord = -1
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return header, err
+}
+
+// isGzipFile reports whether filename has a gzip extension.
+func isGzipFile(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".gz")
+}
+
+// writeFileAtomic calls write with a temporary file next to filename and
+// renames it over filename once everything has been flushed to disk.
+func writeFileAtomic(filename string, write func(io.Writer) error) error {
+	tmp := filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := replaceFile(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeCSVRecords writes the header, unless opts.SkipHeader is set, and one
+// record per entry to w. Without opts.Columns the Notes column is only
+// written when some entry has notes, so files without any stay as they were.
+func writeCSVRecords(w io.Writer, entries []Entry, opts CSVOptions) error {
+	writer := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		writer.Comma = opts.Delimiter
+	}
+	columns := opts.Columns
+	if columns == nil {
+		columns = FieldNames
+		if slices.ContainsFunc(entries, func(e Entry) bool { return e.Notes != "" }) {
+			columns = allFields
+		}
+	}
+	if !opts.SkipHeader {
+		writer.Write(columns)
+	}
+	for _, e := range entries {
+		writer.Write(entryColumns(e, columns))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteCSVOrdered writes only the named columns of entries to the CSV file,
+// in the given order. It returns an error if a column is not an Entry field.
+func WriteCSVOrdered(filename string, entries []Entry, columns []string) error {
+	resolved, err := ResolveColumns(columns)
+	if err != nil {
+		return err
+	}
+	return WriteCSV(filename, entries, CSVOptions{Columns: resolved})
+}
+
+// isFieldPermutation reports whether columns names every field exactly once,
+// with or without Notes.
+func isFieldPermutation(columns []string) bool {
+	return headerOrder(columns) != nil
+}
+
+// headerOrder maps each column of a CSV header to its index in allFields.
+// It returns nil unless the header names every field exactly once; Notes
+// may be left out.
+func headerOrder(header []string) []int {
+	if len(header) != len(FieldNames) && len(header) != len(allFields) {
+		return nil
+	}
+	order := make([]int, len(header))
+	seen := make(map[string]bool)
+	for i, h := range header {
+		name, ok := CanonicalField(strings.TrimSpace(h))
+		if !ok || seen[name] {
+			return nil
+		}
+		seen[name] = true
+		order[i] = slices.Index(allFields, name)
+	}
+	if len(header) == len(FieldNames) && seen["Notes"] {
+		return nil
+	}
+	return order
+}
+
+// replaceFile renames src over dst. Windows refuses to rename onto an
+// existing file, so dst is removed first there.
+func replaceFile(src, dst string) error {
+	if runtime.GOOS == "windows" {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(src, dst)
+}
+
+// backupTimeFormat is the timestamp layout used in backup file names.
+const backupTimeFormat = "20060102_150405"
+
+// splitExt splits a file name into its base and its extension, treating
+// ".csv.gz" as a single extension.
+func splitExt(filename string) (string, string) {
+	ext := filepath.Ext(filename)
+	if strings.EqualFold(ext, ".gz") {
+		ext = filepath.Ext(strings.TrimSuffix(filename, ext)) + ext
+	}
+	return strings.TrimSuffix(filename, ext), ext
+}
+
+// BackupCSV copies filename to a timestamped sibling such as
+// fixlets_20240115_143022.csv and returns the backup path.
+func BackupCSV(filename string) (string, error) {
+	base, ext := splitExt(filename)
+	backup := base + "_" + time.Now().Format(backupTimeFormat) + ext
+	src, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	err = writeFileAtomic(backup, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// ListBackups returns the backups of filename made by BackupCSV, oldest first.
+func ListBackups(filename string) ([]string, error) {
+	base, ext := splitExt(filename)
+	matches, err := filepath.Glob(base + "_*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, base+"_"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// ExportJSON writes the list of entries to a pretty-printed JSON file.
+func ExportJSON(entries []Entry, filename string) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// ExportJSONColumns writes entries to a pretty-printed JSON file, keeping only
+// the named columns in each object, in the given order.
+func ExportJSONColumns(entries []Entry, columns []string, filename string) error {
+	resolved, err := ResolveColumns(columns)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i, e := range entries {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for j, c := range resolved {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			var value any = fieldString(e, c)
+			if isNumericField(c) {
+				value = fieldInt(e, c)
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "\n    %q: %s", c, data)
+		}
+		b.WriteString("\n  }")
+	}
+	if len(entries) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+	return os.WriteFile(filename, b.Bytes(), 0644)
+}
+
+// ImportJSON reads a JSON array of entries from a file. The entries are
+// checked with ValidateEntries, so a file with an invalid value or a FixletID
+// used twice is rejected as a whole.
+func ImportJSON(filename string) ([]Entry, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	if errs := ValidateEntries(entries); len(errs) > 1 {
+		return nil, fmt.Errorf("%w (and %d more problems)", errs[0], len(errs)-1)
+	} else if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return entries, nil
+}
+
+// ExportMarkdown writes entries to w as a GitHub-flavored Markdown table.
+func ExportMarkdown(entries []Entry, w io.Writer) error {
+	return ExportMarkdownColumns(entries, FieldNames, w)
+}
+
+// ExportMarkdownColumns is ExportMarkdown restricted to the named columns.
+func ExportMarkdownColumns(entries []Entry, columns []string, w io.Writer) error {
+	resolved, err := ResolveColumns(columns)
+	if err != nil {
+		return err
+	}
+	return writeMarkdownTable(w, resolved, selectColumns(entries, resolved))
+}
+
+// writeMarkdownTable writes a pipe table with every column padded to its
+// widest cell so the raw Markdown stays readable.
+func writeMarkdownTable(w io.Writer, headers []string, rows [][]string) error {
+	escaped := make([][]string, len(rows))
+	for i, row := range rows {
+		escaped[i] = make([]string, len(row))
+		for j, cell := range row {
+			escaped[i][j] = strings.ReplaceAll(cell, "|", "\\|")
+		}
+	}
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = max(utf8.RuneCountInString(h), 3)
+	}
+	for _, row := range escaped {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	writeRow := func(cells []string) error {
+		var b strings.Builder
+		b.WriteString("|")
+		for i, cell := range cells {
+			b.WriteString(" " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " |")
+		}
+		b.WriteString("\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	if err := writeRow(headers); err != nil {
+		return err
+	}
+	separator := make([]string, len(headers))
+	for i, n := range widths {
+		separator[i] = strings.Repeat("-", n)
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range escaped {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportHTML writes entries to w as an HTML table. Each row carries a CSS
+// class derived from its Criticality, such as "criticality-critical".
+func ExportHTML(entries []Entry, w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("<table>\n<thead>\n<tr>")
+	for _, h := range FieldNames {
+		b.WriteString("<th>" + html.EscapeString(h) + "</th>")
+	}
+	b.WriteString("</tr>\n</thead>\n<tbody>\n")
+	for _, e := range entries {
+		class := "criticality-" + strings.ReplaceAll(strings.ToLower(strings.TrimSpace(e.Criticality)), " ", "-")
+		fmt.Fprintf(&b, "<tr class=\"%s\">", html.EscapeString(class))
+		for _, cell := range entryRecord(e) {
+			b.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</tbody>\n</table>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ExportHTMLPage writes entries to w as a self-contained HTML page with inline CSS.
+func ExportHTMLPage(entries []Entry, w io.Writer) error {
+	if _, err := io.WriteString(w, htmlPageHeader); err != nil {
+		return err
+	}
+	if err := ExportHTML(entries, w); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "</body>\n</html>\n")
+	return err
+}
+
+const htmlPageHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Fixlets</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+tr.criticality-critical { background: #f8d7da; }
+tr.criticality-high, tr.criticality-important { background: #fde5cc; }
+tr.criticality-medium, tr.criticality-moderate { background: #fff3cd; }
+tr.criticality-low { background: #e2f0d9; }
+</style>
+</head>
+<body>
+`
+
+// sqlIdentifier matches the table names ExportSQL accepts, optionally
+// qualified by a schema.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// ExportSQL writes one INSERT statement per entry into tableName to w.
+func ExportSQL(entries []Entry, tableName string, w io.Writer) error {
+	return writeSQLInserts(entries, tableName, false, w)
+}
+
+// ExportSQLUpsert is ExportSQL with PostgreSQL's ON CONFLICT clause, so that
+// an entry whose FixletID already exists in the table updates it instead.
+func ExportSQLUpsert(entries []Entry, tableName string, w io.Writer) error {
+	return writeSQLInserts(entries, tableName, true, w)
+}
+
+// writeSQLInserts writes the INSERT statements for ExportSQL and
+// ExportSQLUpsert.
+func writeSQLInserts(entries []Entry, tableName string, upsert bool, w io.Writer) error {
+	if !sqlIdentifier.MatchString(tableName) {
+		return fmt.Errorf("invalid table name %q", tableName)
+	}
+	columns := strings.Join(FieldNames, ", ")
+	var conflict string
+	if upsert {
+		var set []string
+		for _, f := range FieldNames {
+			if f != "FixletID" {
+				set = append(set, f+" = EXCLUDED."+f)
+			}
+		}
+		conflict = " ON CONFLICT (FixletID) DO UPDATE SET " + strings.Join(set, ", ")
+	}
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		fmt.Fprintf(bw, "INSERT INTO %s (%s) VALUES (%d, %d, %s, %s, %d)%s;\n",
+			tableName, columns, e.SiteID, e.FixletID, sqlString(e.Name), sqlString(e.Criticality), e.RelevantComputerCount, conflict)
+	}
+	return bw.Flush()
+}
+
+// sqlString quotes s as an SQL string literal, doubling any single quotes.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// writeToFileOrStdout calls write with the named file, or with stdout when
+// filename is empty.
+func writeToFileOrStdout(filename string, write func(io.Writer) error) error {
+	if filename == "" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// EntryChange holds both versions of an entry whose fields differ.
+type EntryChange struct {
+	Before Entry
+	After  Entry
+}
+
+// EntryDiff is the result of comparing two datasets by FixletID.
+type EntryDiff struct {
+	OnlyInA []Entry
+	OnlyInB []Entry
+	Changed []EntryChange
+}
+
+// DiffEntries compares a and b by FixletID. When a FixletID appears more
+// than once in a dataset, only its first entry is compared.
+func DiffEntries(a, b []Entry) EntryDiff {
+	var diff EntryDiff
+	inA := make(map[int]Entry, len(a))
+	for _, e := range a {
+		if _, seen := inA[e.FixletID]; !seen {
+			inA[e.FixletID] = e
+		}
+	}
+	inB := make(map[int]Entry, len(b))
+	for _, e := range b {
+		if _, seen := inB[e.FixletID]; !seen {
+			inB[e.FixletID] = e
+		}
+	}
+	compared := make(map[int]bool, len(a))
+	for _, e := range a {
+		if compared[e.FixletID] {
+			continue
+		}
+		compared[e.FixletID] = true
+		other, ok := inB[e.FixletID]
+		switch {
+		case !ok:
+			diff.OnlyInA = append(diff.OnlyInA, e)
+		case other != e:
+			diff.Changed = append(diff.Changed, EntryChange{e, other})
+		}
+	}
+	for _, e := range b {
+		if !compared[e.FixletID] {
+			compared[e.FixletID] = true
+			diff.OnlyInB = append(diff.OnlyInB, e)
+		}
+	}
+	return diff
+}
+
+// PrintDiff writes diff to w with "-" for entries only in the first
+// dataset, "+" for entries only in the second and both lines for changed
+// entries.
+func PrintDiff(diff EntryDiff, w io.Writer) {
+	if len(diff.OnlyInA)+len(diff.OnlyInB)+len(diff.Changed) == 0 {
+		fmt.Fprintln(w, "No differences.")
+		return
+	}
+	for _, e := range diff.OnlyInA {
+		fmt.Fprintln(w, "- "+formatEntry(e))
+	}
+	for _, e := range diff.OnlyInB {
+		fmt.Fprintln(w, "+ "+formatEntry(e))
+	}
+	for _, c := range diff.Changed {
+		fmt.Fprintln(w, "- "+formatEntry(c.Before))
+		fmt.Fprintln(w, "+ "+formatEntry(c.After))
+	}
+	fmt.Fprintf(w, "%d removed, %d added, %d changed.\n", len(diff.OnlyInA), len(diff.OnlyInB), len(diff.Changed))
+}
+
+// GroupBySiteID groups entries by SiteID, preserving their order within each group.
+func GroupBySiteID(entries []Entry) map[int][]Entry {
+	groups := make(map[int][]Entry)
+	for _, e := range entries {
+		groups[e.SiteID] = append(groups[e.SiteID], e)
+	}
+	return groups
+}
+
+// GroupByCriticality groups entries by Criticality, preserving their order within each group.
+func GroupByCriticality(entries []Entry) map[string][]Entry {
+	groups := make(map[string][]Entry)
+	for _, e := range entries {
+		groups[e.Criticality] = append(groups[e.Criticality], e)
+	}
+	return groups
+}
+
+// SplitBySiteID writes the entries of every SiteID to its own file,
+// <dir>/site_<id>.csv, creating dir if needed and replacing existing files.
+// It returns the file written for each SiteID.
+func SplitBySiteID(entries []Entry, dir string, opts CSVOptions) (map[int]string, error) {
+	return writeGroups(GroupBySiteID(entries), dir, func(id int) string {
+		return fmt.Sprintf("site_%d.csv", id)
+	}, true, opts)
+}
+
+// SplitByCriticality writes the entries of every criticality level to its
+// own file, <dir>/<criticality>.csv, with the level lower-cased and every
+// character other than a-z, 0-9, "-" and "_" replaced by an underscore, so
+// a level cannot name a file outside dir. Levels that map to the same file
+// are an error. Unless overwrite is set, nothing is written if any of the
+// files already exists. It returns the file written for each level.
+func SplitByCriticality(entries []Entry, dir string, overwrite bool, opts CSVOptions) (map[string]string, error) {
+	groups := GroupByCriticality(entries)
+	levels := make(map[string]string, len(groups))
+	for _, c := range slices.Sorted(maps.Keys(groups)) {
+		name := criticalityFilename(c)
+		if other, taken := levels[name]; taken {
+			return nil, fmt.Errorf("criticality levels %q and %q would both be written to %s", other, c, name)
+		}
+		levels[name] = c
+	}
+	return writeGroups(groups, dir, criticalityFilename, overwrite, opts)
+}
+
+// criticalityFilename returns the file SplitByCriticality writes level to.
+func criticalityFilename(level string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(level))
+	return name + ".csv"
+}
+
+// writeGroups writes each group to a CSV file in dir named by filename.
+// Without overwrite it fails before writing anything if a file exists.
+func writeGroups[K cmp.Ordered](groups map[K][]Entry, dir string, filename func(K) string, overwrite bool, opts CSVOptions) (map[K]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	keys := slices.Sorted(maps.Keys(groups))
+	if !overwrite {
+		for _, key := range keys {
+			path := filepath.Join(dir, filename(key))
+			if _, err := os.Stat(path); err == nil {
+				return nil, fmt.Errorf("%s already exists; use --overwrite to replace it", path)
+			}
+		}
+	}
+	files := make(map[K]string, len(groups))
+	for _, key := range keys {
+		path := filepath.Join(dir, filename(key))
+		if err := WriteCSV(path, groups[key], opts); err != nil {
+			return files, err
+		}
+		files[key] = path
+	}
+	return files, nil
+}
+
+// printSplitSummary lists the files written by a split, with the number of
+// entries in each.
+func printSplitSummary[K cmp.Ordered](files map[K]string, groups map[K][]Entry) {
+	for _, key := range slices.Sorted(maps.Keys(files)) {
+		infof("  %s: %d entries\n", files[key], len(groups[key]))
+	}
+	infof("%d files written.\n", len(files))
+}
+
+// PrintGrouped writes each SiteID as a heading followed by its entries and a
+// subtotal of RelevantComputerCount.
+func PrintGrouped(groups map[int][]Entry, w io.Writer) {
+	printGroups(w, "SiteID", groups)
+}
+
+// PrintGroupedByCriticality is PrintGrouped for groups keyed by Criticality.
+func PrintGroupedByCriticality(groups map[string][]Entry, w io.Writer) {
+	printGroups(w, "Criticality", groups)
+}
+
+// printGroups writes groups in key order under headings of the form "label key".
+func printGroups[K cmp.Ordered](w io.Writer, label string, groups map[K][]Entry) {
+	keys := make([]K, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s %v\n", label, k)
+		total := 0
+		for _, e := range groups[k] {
+			fmt.Fprintln(w, "  "+formatEntry(e))
+			total += e.RelevantComputerCount
+		}
+		fmt.Fprintf(w, "  Subtotal: %d fixlets, %d computers\n", len(groups[k]), total)
+	}
+}
+
+// SumComputersByCriticality totals RelevantComputerCount for each
+// criticality level.
+func SumComputersByCriticality(entries []Entry) map[string]int {
+	sums := make(map[string]int)
+	for _, e := range entries {
+		sums[e.Criticality] += e.RelevantComputerCount
+	}
+	return sums
+}
+
+// SumComputersBySiteID totals RelevantComputerCount for each SiteID.
+func SumComputersBySiteID(entries []Entry) map[int]int {
+	sums := make(map[int]int)
+	for _, e := range entries {
+		sums[e.SiteID] += e.RelevantComputerCount
+	}
+	return sums
+}
+
+// PrintComputerSums writes sums to w as a table headed label, largest total
+// first, or as a JSON object when format is "json".
+func PrintComputerSums[K cmp.Ordered](sums map[K]int, label, format string, w io.Writer) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(sums, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	case "", "text", "table":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	keys := slices.Sorted(maps.Keys(sums))
+	sort.SliceStable(keys, func(i, j int) bool { return sums[keys[i]] > sums[keys[j]] })
+	rows := make([][]string, len(keys))
+	for i, k := range keys {
+		rows[i] = []string{fmt.Sprint(k), strconv.Itoa(sums[k])}
+	}
+	writeTable(w, []string{label, "Computers"}, rows)
+	return nil
+}
+
+// MergeStrategy decides what happens when an incoming entry has the same
+// FixletID as an existing one.
+type MergeStrategy int
+
+const (
+	// MergeSkip keeps the existing entry.
+	MergeSkip MergeStrategy = iota
+	// MergeOverwrite replaces the existing entry with the incoming one.
+	MergeOverwrite
+	// MergeFail aborts with an error listing every conflict.
+	MergeFail
+)
+
+// ParseMergeStrategy converts "skip", "overwrite" or "fail" into a MergeStrategy.
+func ParseMergeStrategy(s string) (MergeStrategy, error) {
+	switch strings.ToLower(s) {
+	case "skip":
+		return MergeSkip, nil
+	case "overwrite":
+		return MergeOverwrite, nil
+	case "fail":
+		return MergeFail, nil
+	}
+	return 0, fmt.Errorf("unknown merge strategy %q", s)
+}
+
+// ImportReport counts what happened to each record during an import, as
+// MergeReport does for a merge; Overwritten is its Replaced. RowErrors lists
+// the problematic rows of the source file, which ReadCSV leaves out unless
+// only their values are invalid.
+type ImportReport struct {
+	Added       int
+	Skipped     int
+	Overwritten int
+	Conflicted  int
+	RowErrors   []RowError
+}
+
+// ImportCSV merges the entries of srcFilename into dest with MergeEntries,
+// resolving FixletID conflicts according to strategy. With MergeFail, dest is
+// returned unchanged along with an error wrapping ErrDuplicateFxiletID that
+// lists every conflict.
+func ImportCSV(dest []Entry, srcFilename string, strategy MergeStrategy, opts CSVOptions) ([]Entry, ImportReport, error) {
+	return ImportCSVContext(context.Background(), dest, srcFilename, strategy, opts)
+}
+
+// ImportCSVContext imports like ImportCSV but gives up, leaving dest
+// unchanged, if ctx is done before srcFilename has been read.
+func ImportCSVContext(ctx context.Context, dest []Entry, srcFilename string, strategy MergeStrategy, opts CSVOptions) ([]Entry, ImportReport, error) {
+	src, rowErrors, err := ReadCSVContext(ctx, srcFilename, opts)
+	if err != nil {
+		return dest, ImportReport{}, err
+	}
+	for i := range rowErrors {
+		rowErrors[i].File = srcFilename
+	}
+	merged, mr := MergeEntries(dest, src, strategy)
+	report := ImportReport{Added: mr.Added, Skipped: mr.Skipped, Overwritten: mr.Replaced, Conflicted: mr.Conflicted, RowErrors: rowErrors}
+	if strategy == MergeFail && mr.Conflicted > 0 {
+		return dest, report, mr.conflictError()
+	}
+	return merged, report, nil
+}
+
+// MergeReport counts what happened to each overlay record during a merge.
+// Conflicted counts overlay records that share a FixletID with a base record
+// but differ from it; each of them is also counted as skipped or replaced,
+// unless the merge was aborted by MergeFail.
+type MergeReport struct {
+	Added       int
+	Skipped     int
+	Replaced    int
+	Conflicted  int
+	ConflictIDs []int // FixletIDs of the conflicted records
+}
+
+// conflictError returns an error wrapping ErrDuplicateFxiletID that lists
+// the conflicted FixletIDs.
+func (r MergeReport) conflictError() error {
+	conflicts := make([]string, len(r.ConflictIDs))
+	for i, id := range r.ConflictIDs {
+		conflicts[i] = strconv.Itoa(id)
+	}
+	return fmt.Errorf("%w: %s", ErrDuplicateFxiletID, strings.Join(conflicts, ", "))
+}
+
+// MergeEntries returns the union of base and overlay by FixletID. Overlay
+// entries with a new FixletID are appended; entries matching an existing one
+// are skipped or replace it depending on strategy. Identical records are
+// always skipped. With MergeFail and at least one conflict, base is returned
+// unchanged and only Conflicted and ConflictIDs are set.
+func MergeEntries(base, overlay []Entry, strategy MergeStrategy) ([]Entry, MergeReport) {
+	var report MergeReport
+	index := make(map[int]int, len(base))
+	for i, e := range base {
+		if _, exists := index[e.FixletID]; !exists {
+			index[e.FixletID] = i
+		}
+	}
+	merged := slices.Clone(base)
+	for _, e := range overlay {
+		i, exists := index[e.FixletID]
+		switch {
+		case !exists:
+			index[e.FixletID] = len(merged)
+			merged = append(merged, e)
+			report.Added++
+		case merged[i] == e:
+			report.Skipped++
+		case strategy == MergeOverwrite:
+			merged[i] = e
+			report.Replaced++
+			report.Conflicted++
+			report.ConflictIDs = append(report.ConflictIDs, e.FixletID)
+		default:
+			report.Skipped++
+			report.Conflicted++
+			report.ConflictIDs = append(report.ConflictIDs, e.FixletID)
+		}
+	}
+	if strategy == MergeFail && report.Conflicted > 0 {
+		return base, MergeReport{Conflicted: report.Conflicted, ConflictIDs: report.ConflictIDs}
+	}
+	return merged, report
+}
+
+// formatEntry renders an entry on a single line.
+func formatEntry(e Entry) string {
+	s := fmt.Sprintf("SiteID: %d, FixletID: %d, Name: %s, Criticality: %s, Computers: %d", e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
+	if e.Notes != "" {
+		s += ", Notes: " + e.Notes
+	}
+	return s
+}
+
+// ListEntries writes every entry to w, one per line.
+func ListEntries(entries []Entry, w io.Writer) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No entries available.")
+		return
+	}
+	for _, e := range entries {
+		fmt.Fprintln(w, formatEntry(e))
+	}
+}
+
+// PrintTable writes entries to w as an aligned table with a header row.
+func PrintTable(entries []Entry, w io.Writer) {
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		rows[i] = entryRecord(e)
+	}
+	writeTable(w, FieldNames, rows)
+}
+
+// entryRecord returns the fields of e as strings in CSV column order.
+func entryRecord(e Entry) []string {
+	return []string{strconv.Itoa(e.SiteID), strconv.Itoa(e.FixletID), e.Name, e.Criticality, strconv.Itoa(e.RelevantComputerCount)}
+}
+
+// writeTable writes a header row, a separator row of dashes and the data rows
+// to w, padding each column to its widest value.
+func writeTable(w io.Writer, headers []string, rows [][]string) {
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	writeRow := func(cells []string) {
+		for i, cell := range cells {
+			if i > 0 {
+				fmt.Fprint(w, "  ")
+			}
+			if i == len(cells)-1 {
+				fmt.Fprint(w, cell)
+			} else {
+				fmt.Fprint(w, cell, strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	writeRow(headers)
+	separator := make([]string, len(headers))
+	for i, n := range widths {
+		separator[i] = strings.Repeat("-", n)
+	}
+	writeRow(separator)
+	for _, row := range rows {
+		writeRow(row)
+	}
+}
+
+// ListEntriesPage writes a single page of entries to w followed by a summary
+// line. Pages are numbered from 1.
+func ListEntriesPage(entries []Entry, page, pageSize int, w io.Writer) {
+	listPage(entries, page, pageSize, w, func(page []Entry) { ListEntries(page, w) })
+}
+
+// listPage displays one page of entries with show, followed by a
+// "Page 2/47 (entries 51-100 of 2350)" summary line on w.
+func listPage(entries []Entry, page, pageSize int, w io.Writer, show func([]Entry)) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No entries available.")
+		return
+	}
+	if page < 1 || pageSize < 1 {
+		fmt.Fprintln(w, "Page and page size must be positive.")
+		return
+	}
+	pages := (len(entries) + pageSize - 1) / pageSize
+	if page > pages {
+		fmt.Fprintf(w, "Page %d is out of range (%d pages).\n", page, pages)
+		return
+	}
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+	show(entries[start:end])
+	if !QuietMode {
+		fmt.Fprintf(w, "Page %d/%d (entries %d-%d of %d)\n", page, pages, start+1, end, len(entries))
+	}
+}
+
+// ResolveColumns maps user-supplied column names to Entry field names,
+// returning an error for any name that is not a field.
+func ResolveColumns(columns []string) ([]string, error) {
+	resolved := make([]string, len(columns))
+	for i, c := range columns {
+		name, ok := CanonicalField(strings.TrimSpace(c))
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", c)
+		}
+		resolved[i] = name
+	}
+	return resolved, nil
+}
+
+// selectColumns returns the named canonical fields of every entry as rows.
+func selectColumns(entries []Entry, columns []string) [][]string {
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		rows[i] = entryColumns(e, columns)
+	}
+	return rows
+}
+
+// entryColumns returns the named canonical fields of e.
+func entryColumns(e Entry, columns []string) []string {
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = fieldString(e, c)
+	}
+	return record
+}
+
+// PrintSelectedColumns writes entries to w as an aligned table holding only
+// the named columns, in the given order.
+func PrintSelectedColumns(entries []Entry, columns []string, w io.Writer) error {
+	resolved, err := ResolveColumns(columns)
+	if err != nil {
+		return err
+	}
+	writeTable(w, resolved, selectColumns(entries, resolved))
+	return nil
+}
+
+// QueryEntries returns all entries whose name or criticality contains the query.
+func QueryEntries(entries []Entry, query string) []Entry {
+	query = strings.ToLower(query)
+	var matches []Entry
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Name), query) || strings.Contains(strings.ToLower(e.Criticality), query) {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
+
+// QueryByNote returns all entries whose notes contain q, ignoring case.
+func QueryByNote(entries []Entry, q string) []Entry {
+	q = strings.ToLower(q)
+	return FilterEntries(entries, func(e Entry) bool {
+		return e.Notes != "" && strings.Contains(strings.ToLower(e.Notes), q)
+	})
+}
+
+// QueryEntry searches for entries by name or criticality and writes every
+// match to w.
+func QueryEntry(entries []Entry, query string, w io.Writer) []Entry {
+	matches := QueryEntries(entries, query)
+	if len(matches) == 0 {
+		fmt.Fprintln(w, "No entries found.")
+		return matches
+	}
+	for _, e := range matches {
+		fmt.Fprintln(w, formatEntry(e))
+	}
+	return matches
+}
+
+// QueryEntryRegex returns all entries whose name or criticality matches the
+// regular expression pattern.
+func QueryEntryRegex(entries []Entry, pattern string) ([]Entry, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	var matches []Entry
+	for _, e := range entries {
+		if re.MatchString(e.Name) || re.MatchString(e.Criticality) {
+			matches = append(matches, e)
+		}
+	}
+	return matches, nil
+}
+
+// QueryBySiteID returns all entries with the given SiteID. SiteIDs must be positive.
+func QueryBySiteID(entries []Entry, siteID int) ([]Entry, error) {
+	if siteID <= 0 {
+		return nil, fmt.Errorf("invalid SiteID %d: must be a positive number", siteID)
+	}
+	var matches []Entry
+	for _, e := range entries {
+		if e.SiteID == siteID {
+			matches = append(matches, e)
+		}
+	}
+	return matches, nil
+}
+
+// NormalizeName returns s in title case: every word starts with an upper-case
+// letter and the rest is lower case. A word is a run of letters, digits,
+// underscores and apostrophes.
+func NormalizeName(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	inWord := false
+	for _, r := range s {
+		if inWord {
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(unicode.ToTitle(r))
+		}
+		inWord = unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '\''
+	}
+	return b.String()
+}
+
+// FindCaseVariants groups entries whose names are spelled differently but
+// are identical once normalized with NormalizeName. Groups are returned in
+// order of first appearance.
+func FindCaseVariants(entries []Entry) [][]Entry {
+	groups := make(map[string][]Entry)
+	var order []string
+	for _, e := range entries {
+		key := NormalizeName(e.Name)
+		if _, seen := groups[key]; !seen {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], e)
+	}
+	var variants [][]Entry
+	for _, key := range order {
+		group := groups[key]
+		for _, e := range group[1:] {
+			if e.Name != group[0].Name {
+				variants = append(variants, group)
+				break
+			}
+		}
+	}
+	return variants
+}
+
+// FieldNames lists the Entry fields in CSV column order.
+var FieldNames = []string{"SiteID", "FixletID", "Name", "Criticality", "RelevantComputerCount"}
+
+// allFields is FieldNames followed by the optional Notes column.
+var allFields = append(slices.Clone(FieldNames), "Notes")
+
+// fieldAlias maps lower-case short names to the fields they stand for.
+var fieldAlias = map[string]string{
+	"fxiletid":  "FixletID",
+	"id":        "FixletID",
+	"site":      "SiteID",
+	"computers": "RelevantComputerCount",
+	"count":     "RelevantComputerCount",
+}
+
+// RegisterAlias lets alias be used, in any letter case, wherever a field
+// name is accepted. field may itself be any name CanonicalField resolves.
+func RegisterAlias(alias, field string) error {
+	name, ok := CanonicalField(field)
+	if !ok {
+		return fmt.Errorf("unknown field %q", field)
+	}
+	if _, ok := CanonicalField(alias); ok && fieldAlias[strings.ToLower(alias)] == "" {
+		return fmt.Errorf("%q is already a field name", alias)
+	}
+	fieldAlias[strings.ToLower(alias)] = name
+	return nil
+}
+
+// PrintAliases writes every alias and the field it stands for to w.
+func PrintAliases(w io.Writer) {
+	aliases := slices.Sorted(maps.Keys(fieldAlias))
+	rows := make([][]string, len(aliases))
+	for i, a := range aliases {
+		rows[i] = []string{a, fieldAlias[a]}
+	}
+	writeTable(w, []string{"Alias", "Field"}, rows)
+}
+
+// CanonicalField resolves a user-supplied field name case-insensitively.
+// The names in fieldAlias are accepted as synonyms.
+func CanonicalField(name string) (string, bool) {
+	if field, ok := fieldAlias[strings.ToLower(name)]; ok {
+		return field, true
+	}
+	for _, f := range allFields {
+		if strings.EqualFold(f, name) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// isNumericField reports whether the canonical field holds an integer.
+func isNumericField(field string) bool {
+	return field == "SiteID" || field == "FixletID" || field == "RelevantComputerCount"
+}
+
+// fieldInt returns the value of a numeric canonical field.
+func fieldInt(e Entry, field string) int {
+	switch field {
+	case "SiteID":
+		return e.SiteID
+	case "FixletID":
+		return e.FixletID
+	case "RelevantComputerCount":
+		return e.RelevantComputerCount
+	}
+	return 0
+}
+
+// fieldString returns the value of a canonical field formatted as a string.
+func fieldString(e Entry, field string) string {
+	switch field {
+	case "Name":
+		return e.Name
+	case "Criticality":
+		return e.Criticality
+	case "Notes":
+		return e.Notes
+	}
+	return strconv.Itoa(fieldInt(e, field))
+}
+
+// FilterEntries returns every entry that satisfies pred.
+func FilterEntries(entries []Entry, pred func(Entry) bool) []Entry {
+	var matches []Entry
+	for _, e := range entries {
+		if pred(e) {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
+
+// LogicalOp says how ComposeFilters combines its filters.
+type LogicalOp int
+
+const (
+	And LogicalOp = iota // every filter must match
+	Or                   // at least one filter must match
+)
+
+// ComposeFilters returns a predicate that combines filters with op. With no
+// filters, And matches every entry and Or matches none.
+func ComposeFilters(filters []func(Entry) bool, op LogicalOp) func(Entry) bool {
+	if op == Or {
+		return func(e Entry) bool {
+			for _, f := range filters {
+				if f(e) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return func(e Entry) bool {
+		for _, f := range filters {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+var (
+	orKeyword  = regexp.MustCompile(`(?i)\s+OR\s+`)
+	andKeyword = regexp.MustCompile(`(?i)\s+AND\s+`)
+)
+
+// ParseFilter parses a filter expression into a predicate. The expression is
+// one or more conditions of the form "field op value", where op is one of =,
+// !=, <, >, <= or >=, joined by AND or OR in any letter case, with AND
+// binding tighter than OR: "Criticality=High AND Computers>100". String
+// fields compare case-insensitively for = and !=. Surrounding quotes are
+// ignored.
+func ParseFilter(expr string) (func(Entry) bool, error) {
+	expr = strings.TrimSpace(expr)
+	if len(expr) >= 2 && (expr[0] == '"' || expr[0] == '\'') && expr[len(expr)-1] == expr[0] {
+		expr = expr[1 : len(expr)-1]
+	}
+	var alternatives []func(Entry) bool
+	for _, part := range orKeyword.Split(expr, -1) {
+		var conditions []func(Entry) bool
+		for _, cond := range andKeyword.Split(part, -1) {
+			pred, err := parseCondition(cond)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, pred)
+		}
+		if len(conditions) == 1 {
+			alternatives = append(alternatives, conditions[0])
+		} else {
+			alternatives = append(alternatives, ComposeFilters(conditions, And))
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return ComposeFilters(alternatives, Or), nil
+}
+
+// parseCondition parses a single "field op value" condition of a filter.
+func parseCondition(expr string) (func(Entry) bool, error) {
+	i := strings.IndexAny(expr, "=!<>")
+	if i < 0 {
+		return nil, fmt.Errorf("malformed filter %q: expected field op value", expr)
+	}
+	op := expr[i : i+1]
+	if i+1 < len(expr) && expr[i+1] == '=' {
+		op = expr[i : i+2]
+	}
+	if op == "!" {
+		return nil, fmt.Errorf("malformed filter %q: unknown operator", expr)
+	}
+	return comparison(expr, strings.TrimSpace(expr[:i]), op, strings.TrimSpace(expr[i+len(op):]))
+}
+
+// comparison returns a predicate comparing the named field of an entry with
+// value using op, one of =, !=, <, >, <= and >=. Strings compare equal
+// ignoring case. expr is the condition quoted in errors.
+func comparison(expr, name, op, value string) (func(Entry) bool, error) {
+	field, ok := CanonicalField(name)
+	if !ok {
+		return nil, fmt.Errorf("malformed filter %q: unknown field %q", expr, name)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("malformed filter %q: missing value", expr)
+	}
+
+	var cmp func(e Entry) int
+	if isNumericField(field) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed filter %q: %s requires a number", expr, field)
+		}
+		cmp = func(e Entry) int {
+			v := fieldInt(e, field)
+			switch {
+			case v < n:
+				return -1
+			case v > n:
+				return 1
+			}
+			return 0
+		}
+	} else if op == "=" || op == "!=" {
+		cmp = func(e Entry) int {
+			if strings.EqualFold(fieldString(e, field), value) {
+				return 0
+			}
+			return 1
+		}
+	} else {
+		cmp = func(e Entry) int { return strings.Compare(fieldString(e, field), value) }
+	}
+
+	switch op {
+	case "=":
+		return func(e Entry) bool { return cmp(e) == 0 }, nil
+	case "!=":
+		return func(e Entry) bool { return cmp(e) != 0 }, nil
+	case "<":
+		return func(e Entry) bool { return cmp(e) < 0 }, nil
+	case ">":
+		return func(e Entry) bool { return cmp(e) > 0 }, nil
+	case "<=":
+		return func(e Entry) bool { return cmp(e) <= 0 }, nil
+	case ">=":
+		return func(e Entry) bool { return cmp(e) >= 0 }, nil
+	}
+	return nil, fmt.Errorf("malformed filter %q: unknown operator %q", expr, op)
+}
+
+// whereToken is a word, operator or quoted string of a WHERE clause.
+type whereToken struct {
+	text   string
+	quoted bool
+}
+
+// ParseWhereClause parses a SQL-like condition such as
+// "name like '%patch%' and criticality = 'High'" into a predicate. Each
+// condition is a field, an operator (=, !=, <, >, <=, >=, like or not like)
+// and a value, which is quoted if it holds spaces. like matches values that
+// contain the pattern, ignoring case and the % wildcards around it.
+// Conditions are joined by AND and OR, with AND binding tighter;
+// parentheses are not supported.
+func ParseWhereClause(s string) (func(Entry) bool, error) {
+	tokens, err := tokenizeWhere(s)
+	if err != nil {
+		return nil, err
+	}
+	isKeyword := func(i int, word string) bool {
+		return i < len(tokens) && !tokens[i].quoted && strings.EqualFold(tokens[i].text, word)
+	}
+	// groups holds the conditions joined by AND between each OR.
+	groups := [][]func(Entry) bool{nil}
+	for i := 0; ; {
+		if i+2 >= len(tokens) {
+			return nil, fmt.Errorf("malformed where clause %q: expected field op value", s)
+		}
+		field, op := tokens[i].text, strings.ToLower(tokens[i+1].text)
+		i += 2
+		if op == "not" && isKeyword(i, "like") {
+			op = "not like"
+			i++
+		}
+		if i >= len(tokens) {
+			return nil, fmt.Errorf("malformed where clause %q: missing value", s)
+		}
+		value := tokens[i].text
+		i++
+		var pred func(Entry) bool
+		if op == "like" || op == "not like" {
+			pred, err = likeCondition(s, field, value, op == "not like")
+		} else {
+			pred, err = comparison(s, field, op, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		last := len(groups) - 1
+		groups[last] = append(groups[last], pred)
+		switch {
+		case i == len(tokens):
+			alternatives := make([]func(Entry) bool, len(groups))
+			for j, group := range groups {
+				alternatives[j] = ComposeFilters(group, And)
+			}
+			return ComposeFilters(alternatives, Or), nil
+		case isKeyword(i, "and"):
+		case isKeyword(i, "or"):
+			groups = append(groups, nil)
+		default:
+			return nil, fmt.Errorf("malformed where clause %q: expected AND or OR before %q", s, tokens[i].text)
+		}
+		i++
+	}
+}
+
+// likeCondition returns a predicate for "field like pattern", or for "field
+// not like pattern" when negate is set.
+func likeCondition(expr, name, pattern string, negate bool) (func(Entry) bool, error) {
+	field, ok := CanonicalField(name)
+	if !ok {
+		return nil, fmt.Errorf("malformed where clause %q: unknown field %q", expr, name)
+	}
+	pattern = strings.ToLower(strings.Trim(pattern, "%"))
+	return func(e Entry) bool {
+		return strings.Contains(strings.ToLower(fieldString(e, field)), pattern) != negate
+	}, nil
+}
+
+// tokenizeWhere splits a WHERE clause into words, operators and quoted
+// strings. Quotes are single or double; a doubled quote stands for itself.
+func tokenizeWhere(s string) ([]whereToken, error) {
+	var tokens []whereToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			return nil, fmt.Errorf("malformed where clause %q: parentheses are not supported", s)
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(s) {
+					return nil, fmt.Errorf("malformed where clause %q: unterminated string", s)
+				}
+				if s[j] == c {
+					if j+1 < len(s) && s[j+1] == c {
+						b.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			tokens = append(tokens, whereToken{b.String(), true})
+			i = j + 1
+		case strings.IndexByte("=!<>", c) >= 0:
+			j := i + 1
+			for j < len(s) && strings.IndexByte("=<>", s[j]) >= 0 {
+				j++
+			}
+			tokens = append(tokens, whereToken{s[i:j], false})
+			i = j
+		default:
+			j := i
+			for j < len(s) && strings.IndexByte(" \t()'\"=!<>", s[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, whereToken{s[i:j], false})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// SortEntries sorts entries by the given field in ascending or descending order.
+func SortEntries(entries []Entry, field string, descending bool) error {
+	return SortEntriesMulti(entries, []SortKey{{field, descending}})
+}
+
+// SortKey names a field to sort by and its direction.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSortKey parses a key spec such as "Name", "Name,asc" or "Computers,desc".
+func ParseSortKey(spec string) (SortKey, error) {
+	field, direction, _ := strings.Cut(spec, ",")
+	switch strings.ToLower(direction) {
+	case "", "asc":
+		return SortKey{field, false}, nil
+	case "desc":
+		return SortKey{field, true}, nil
+	}
+	return SortKey{}, fmt.Errorf("invalid sort direction %q in %q", direction, spec)
+}
+
+// SortEntriesMulti sorts entries by each key in turn. The sort is stable, so
+// entries that are equal on every key keep their original relative order.
+func SortEntriesMulti(entries []Entry, keys []SortKey) error {
+	cmp, err := keysComparator(keys)
+	if err != nil {
+		return err
+	}
+	SortEntriesBy(entries, cmp)
+	return nil
+}
+
+// keysComparator returns a Comparator that orders entries by each key in turn.
+func keysComparator(keys []SortKey) (Comparator, error) {
+	cmps := make([]Comparator, len(keys))
+	for i, k := range keys {
+		name, ok := CanonicalField(k.Field)
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field %q", k.Field)
+		}
+		cmps[i] = fieldComparator(name)
+		if k.Desc {
+			cmps[i] = Reverse(cmps[i])
+		}
+	}
+	return Chain(cmps...), nil
+}
+
+// Comparator orders two entries, returning a negative number when a sorts
+// before b, a positive number when it sorts after and 0 when they are equal.
+type Comparator func(a, b Entry) int
+
+// SortEntriesBy sorts entries with cmp. The sort is stable.
+func SortEntriesBy(entries []Entry, cmp Comparator) {
+	slices.SortStableFunc(entries, cmp)
+}
+
+// The built-in comparators order entries by a single field, ascending.
+// Names and criticality levels compare as strings, as SortEntries does.
+var (
+	CompareBySiteID      = fieldComparator("SiteID")
+	CompareByFxiletID    = fieldComparator("FixletID")
+	CompareByName        = fieldComparator("Name")
+	CompareByCriticality = fieldComparator("Criticality")
+	CompareByComputers   = fieldComparator("RelevantComputerCount")
+)
+
+// Reverse returns a Comparator that orders entries the opposite way to cmp.
+func Reverse(cmp Comparator) Comparator {
+	return func(a, b Entry) int { return cmp(b, a) }
+}
+
+// Chain returns a Comparator that tries each of cmps in turn, using the
+// first one that tells the entries apart.
+func Chain(cmps ...Comparator) Comparator {
+	return func(a, b Entry) int {
+		for _, cmp := range cmps {
+			if c := cmp(a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
+
+// fieldComparator returns a Comparator for a canonical field name.
+func fieldComparator(field string) Comparator {
+	return func(a, b Entry) int { return compareField(a, b, field) }
+}
+
+// Pipeline applies a chain of operations to a copy of some entries. Each
+// step works on the same slice, so no step allocates a new one:
+//
+//	top := NewPipeline(entries).Filter(critical).Sort(Reverse(CompareByComputers)).Limit(10).Result()
+type Pipeline struct {
+	entries []Entry
+}
+
+// NewPipeline starts a Pipeline over a copy of entries; entries itself is
+// never modified.
+func NewPipeline(entries []Entry) *Pipeline {
+	return &Pipeline{entries: slices.Clone(entries)}
+}
+
+// Filter keeps the entries for which pred returns true.
+func (p *Pipeline) Filter(pred func(Entry) bool) *Pipeline {
+	p.entries = slices.DeleteFunc(p.entries, func(e Entry) bool { return !pred(e) })
+	return p
+}
+
+// Sort orders the entries with cmp, keeping equal entries in order.
+func (p *Pipeline) Sort(cmp Comparator) *Pipeline {
+	SortEntriesBy(p.entries, cmp)
+	return p
+}
+
+// Limit keeps at most the first n entries.
+func (p *Pipeline) Limit(n int) *Pipeline {
+	p.entries = p.entries[:max(min(n, len(p.entries)), 0)]
+	return p
+}
+
+// Map replaces every entry with the result of transform.
+func (p *Pipeline) Map(transform func(Entry) Entry) *Pipeline {
+	for i, e := range p.entries {
+		p.entries[i] = transform(e)
+	}
+	return p
+}
+
+// Result returns the entries left at the end of the pipeline.
+func (p *Pipeline) Result() []Entry {
+	return p.entries
+}
+
+// ParsePipeline parses a pipeline of "|"-separated steps, such as
+// "filter Criticality=High | sort Computers,desc | limit 10", into a
+// function that runs it. The steps are:
+//
+//	filter <expression>    keep entries matching a ParseFilter expression
+//	sort <key> [<key>...]  sort by ParseSortKey keys, e.g. Name or Computers,desc
+//	limit <n>              keep the first n entries
+//	trim                   trim surrounding spaces from Name and Criticality
+func ParsePipeline(spec string) (func([]Entry) []Entry, error) {
+	var steps []func(*Pipeline)
+	for _, stage := range strings.Split(spec, "|") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(stage), " ")
+		arg = strings.TrimSpace(arg)
+		switch strings.ToLower(name) {
+		case "filter":
+			pred, err := ParseFilter(arg)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, func(p *Pipeline) { p.Filter(pred) })
+		case "sort":
+			specs := strings.Fields(arg)
+			if len(specs) == 0 {
+				return nil, errors.New("sort step needs at least one key")
+			}
+			keys := make([]SortKey, len(specs))
+			for i, spec := range specs {
+				var err error
+				if keys[i], err = ParseSortKey(spec); err != nil {
+					return nil, err
+				}
+			}
+			cmp, err := keysComparator(keys)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, func(p *Pipeline) { p.Sort(cmp) })
+		case "limit":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid limit %q", arg)
+			}
+			steps = append(steps, func(p *Pipeline) { p.Limit(n) })
+		case "trim":
+			steps = append(steps, func(p *Pipeline) { p.Map(trimEntry) })
+		case "":
+			return nil, fmt.Errorf("empty step in pipeline %q", spec)
+		default:
+			return nil, fmt.Errorf("unknown pipeline step %q", name)
+		}
+	}
+	return func(entries []Entry) []Entry {
+		p := NewPipeline(entries)
+		for _, step := range steps {
+			step(p)
+		}
+		return p.Result()
+	}, nil
+}
+
+// TopN returns the n entries with the highest RelevantComputerCount in
+// descending order. entries is not modified.
+func TopN(entries []Entry, n int) []Entry {
+	return firstNSorted(entries, n, true)
+}
+
+// BottomN returns the n entries with the lowest RelevantComputerCount in
+// ascending order. entries is not modified.
+func BottomN(entries []Entry, n int) []Entry {
+	return firstNSorted(entries, n, false)
+}
+
+// RandomSample returns n entries chosen uniformly at random without
+// replacement, in random order. The same seed always picks the same sample.
+// If n is at least len(entries), every entry is returned, shuffled. entries
+// is not modified.
+func RandomSample(entries []Entry, n int, seed int64) []Entry {
+	n = max(min(n, len(entries)), 0)
+	r := rand.New(rand.NewSource(seed))
+	sample := slices.Clone(entries)
+	// A partial Fisher-Yates shuffle: only the first n positions are drawn.
+	for i := 0; i < n; i++ {
+		j := i + r.Intn(len(sample)-i)
+		sample[i], sample[j] = sample[j], sample[i]
+	}
+	return sample[:n]
+}
+
+// ThresholdAlert returns the entries whose RelevantComputerCount exceeds
+// threshold, in their original order.
+func ThresholdAlert(entries []Entry, threshold int) []Entry {
+	return FilterEntries(entries, func(e Entry) bool { return e.RelevantComputerCount > threshold })
+}
+
+// printAlerts lists the entries above threshold with a warning prefix and
+// returns how many there were.
+func printAlerts(entries []Entry, threshold int) int {
+	alerts := ThresholdAlert(entries, threshold)
+	for _, e := range alerts {
+		fmt.Println("[!] " + formatEntry(e))
+	}
+	fmt.Printf("%d entries exceed %d relevant computers.\n", len(alerts), threshold)
+	return len(alerts)
+}
+
+// firstNSorted sorts a copy of entries by RelevantComputerCount and returns
+// at most n of them.
+func firstNSorted(entries []Entry, n int, descending bool) []Entry {
+	sorted := slices.Clone(entries)
+	SortEntries(sorted, "RelevantComputerCount", descending)
+	if n < 0 {
+		n = 0
+	}
+	if n < len(sorted) {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// compareField compares a canonical field of two entries, returning -1, 0 or 1.
+func compareField(a, b Entry, field string) int {
+	if isNumericField(field) {
+		x, y := fieldInt(a, field), fieldInt(b, field)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	if field == "Name" && Locale != "" {
+		return compareNames(a.Name, b.Name)
+	}
+	return strings.Compare(fieldString(a, field), fieldString(b, field))
+}
+
+// Locale, when set with --locale, makes sorting by Name ignore letter case
+// and accents instead of comparing bytes.
+var Locale string
+
+// SortEntriesByName sorts entries by Name in the collation order of locale,
+// keeping equal names in their original order. Without a collation library
+// every locale uses the same order: names are compared with letter case and
+// the accents of Latin letters ignored, so "Édge" sorts between "Database"
+// and "Firmware". An empty locale compares bytes, as SortEntries does.
+func SortEntriesByName(entries []Entry, locale string) {
+	compare := strings.Compare
+	if locale != "" {
+		compare = compareNames
+	}
+	SortEntriesBy(entries, func(a, b Entry) int { return compare(a.Name, b.Name) })
+}
+
+// compareNames orders a and b by their collation keys, breaking ties by
+// their bytes so that the order is total.
+func compareNames(a, b string) int {
+	if c := strings.Compare(collationKey(a), collationKey(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// accentFolds maps accented lower-case Latin letters to their base letters.
+var accentFolds = func() map[rune]string {
+	folds := make(map[rune]string)
+	for base, accented := range map[string]string{
+		"a": "àáâãäåāăą", "ae": "æ", "c": "çćĉċč", "d": "ďđ", "e": "èéêëēĕėęě",
+		"g": "ĝğġģ", "h": "ĥħ", "i": "ìíîïĩīĭįı", "j": "ĵ", "k": "ķ", "l": "ĺļľŀł",
+		"n": "ñńņňŉ", "o": "òóôõöøōŏő", "oe": "œ", "r": "ŕŗř", "s": "śŝşš", "ss": "ß",
+		"t": "ţťŧ", "u": "ùúûüũūŭůűų", "w": "ŵ", "y": "ýÿŷ", "z": "źżž",
+	} {
+		for _, r := range accented {
+			folds[r] = base
+		}
+	}
+	return folds
+}()
+
+// collationKey returns s in lower case with accents removed.
+func collationKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if base, ok := accentFolds[r]; ok {
+			b.WriteString(base)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ErrDuplicateFxiletID is returned when an entry would reuse an existing FixletID.
+var ErrDuplicateFxiletID = errors.New("duplicate FixletID")
+
+// ErrNotFound is returned when no entry has the requested FixletID.
+var ErrNotFound = errors.New("entry not found")
+
+// hasFixletID reports whether any entry has the given FixletID.
+func hasFixletID(entries []Entry, fixletID int) bool {
+	for _, e := range entries {
+		if e.FixletID == fixletID {
+			return true
+		}
+	}
+	return false
+}
+
+// FindDuplicates groups entries that share a FixletID, returning only the
+// groups with more than one member in order of first appearance.
+func FindDuplicates(entries []Entry) [][]Entry {
+	groups := make(map[int][]Entry)
+	var order []int
+	for _, e := range entries {
+		if _, seen := groups[e.FixletID]; !seen {
+			order = append(order, e.FixletID)
+		}
+		groups[e.FixletID] = append(groups[e.FixletID], e)
+	}
+	var dups [][]Entry
+	for _, id := range order {
+		if len(groups[id]) > 1 {
+			dups = append(dups, groups[id])
+		}
+	}
+	return dups
+}
+
+// DeduplicateEntries collapses entries that share a FixletID into one, using
+// resolve to combine each later duplicate with the entry kept so far. The
+// result sits at the position of the first occurrence. It returns the
+// deduplicated entries and the number of entries removed.
+func DeduplicateEntries(entries []Entry, resolve func(a, b Entry) Entry) ([]Entry, int) {
+	pos := make(map[int]int)
+	kept := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if i, seen := pos[e.FixletID]; seen {
+			kept[i] = resolve(kept[i], e)
+			continue
+		}
+		pos[e.FixletID] = len(kept)
+		kept = append(kept, e)
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// KeepFirst resolves a duplicate by keeping the entry that appeared first.
+func KeepFirst(a, b Entry) Entry {
+	return a
+}
+
+// KeepHigherComputers resolves a duplicate by keeping the entry with the
+// higher RelevantComputerCount, preferring the first on a tie.
+func KeepHigherComputers(a, b Entry) Entry {
+	if b.RelevantComputerCount > a.RelevantComputerCount {
+		return b
+	}
+	return a
+}
+
+// ParseResolver converts "first" or "higher-computers" into KeepFirst or
+// KeepHigherComputers.
+func ParseResolver(s string) (func(a, b Entry) Entry, error) {
+	switch strings.ToLower(s) {
+	case "first":
+		return KeepFirst, nil
+	case "higher-computers":
+		return KeepHigherComputers, nil
+	}
+	return nil, fmt.Errorf("unknown duplicate resolver %q (want first or higher-computers)", s)
+}
+
+// NextFxiletID returns one more than the highest FixletID in entries.
+func NextFxiletID(entries []Entry) int {
+	highest := 0
+	for _, e := range entries {
+		if e.FixletID > highest {
+			highest = e.FixletID
+		}
+	}
+	return highest + 1
+}
+
+// maxListedGaps is the most missing FixletIDs id-gaps lists one by one.
+const maxListedGaps = 100
+
+// sortedFixletIDs returns the distinct FixletIDs of entries in ascending order.
+func sortedFixletIDs(entries []Entry) []int {
+	ids := make([]int, len(entries))
+	for i, e := range entries {
+		ids[i] = e.FixletID
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// FindIDGaps returns, in ascending order, the FixletIDs between the lowest
+// and the highest FixletID in entries that no entry uses. IDs that are far
+// apart leave a gap as large as the distance between them; countIDGaps
+// tells how large the result would be without building it.
+func FindIDGaps(entries []Entry) []int {
+	var gaps []int
+	ids := sortedFixletIDs(entries)
+	for i := 1; i < len(ids); i++ {
+		for id := ids[i-1] + 1; id < ids[i]; id++ {
+			gaps = append(gaps, id)
+		}
+	}
+	return gaps
+}
+
+// countIDGaps returns the number of FixletIDs FindIDGaps would return.
+func countIDGaps(entries []Entry) int {
+	ids := sortedFixletIDs(entries)
+	if len(ids) == 0 {
+		return 0
+	}
+	return ids[len(ids)-1] - ids[0] + 1 - len(ids)
+}
+
+// CompactIDs returns a copy of entries with the FixletIDs renumbered 1, 2,
+// 3, ... in the order of the original IDs, so the lowest becomes 1. Entries
+// keep their position, and entries that shared a FixletID still share one.
+func CompactIDs(entries []Entry) []Entry {
+	renumbered := make(map[int]int)
+	for i, id := range sortedFixletIDs(entries) {
+		renumbered[id] = i + 1
+	}
+	compacted := slices.Clone(entries)
+	for i := range compacted {
+		compacted[i].FixletID = renumbered[compacted[i].FixletID]
+	}
+	return compacted
+}
+
+// printIDGaps reports the FixletIDs missing from entries and suggests how to
+// deal with them: a few gaps can be left for new entries to fill, while a
+// sparse ID space is better compacted.
+func printIDGaps(entries []Entry) {
+	n := countIDGaps(entries)
+	if n == 0 {
+		fmt.Println("FixletIDs are contiguous.")
+		return
+	}
+	if n <= maxListedGaps {
+		gaps := FindIDGaps(entries)
+		ids := make([]string, len(gaps))
+		for i, id := range gaps {
+			ids[i] = strconv.Itoa(id)
+		}
+		fmt.Println("Missing FixletIDs:", strings.Join(ids, ", "))
+	}
+	fmt.Printf("%d FixletIDs missing between the lowest and highest of %d entries.\n", n, len(entries))
+	if n <= len(entries)/10 {
+		fmt.Println("The gaps are few; they can be filled by adding entries with those IDs (add with --manual-id).")
+	} else {
+		fmt.Println("The ID space is sparse; compact-ids renumbers the entries from 1 without gaps.")
+	}
+}
+
+// AddOptions controls how AddEntry builds a new entry.
+type AddOptions struct {
+	// ManualID prompts for the FixletID instead of assigning the next free one.
+	ManualID bool
+	// NormalizeNames applies NormalizeName to the entered name.
+	NormalizeNames bool
+}
+
+// AddEntry adds a new entry to the list. The FixletID is assigned
+// automatically unless opts.ManualID is set, in which case the user supplies
+// it and it must not already be in use.
+func AddEntry(entries []Entry, opts AddOptions) ([]Entry, error) {
+	e, err := readNewEntry(entries, opts)
+	if err != nil {
+		return entries, err
+	}
+	return append(entries, e), nil
+}
+
+// readNewEntry prompts for the fields of a new entry as described for
+// AddEntry and returns it validated, without adding it to entries.
+func readNewEntry(entries []Entry, opts AddOptions) (Entry, error) {
+	var siteID, fixletID, relevantComputerCount int
+	var name, criticality string
+	if opts.ManualID {
+		fmt.Println("Enter SiteID, FixletID, Name, Criticality, RelevantComputerCount:")
+		if _, err := fmt.Fscanf(stdin, "%d %d %s %s %d\n", &siteID, &fixletID, &name, &criticality, &relevantComputerCount); err != nil {
+			return Entry{}, err
+		}
+		if fixletID <= 0 {
+			return Entry{}, fmt.Errorf("invalid FixletID %d: must be positive", fixletID)
+		}
+	} else {
+		fmt.Println("Enter SiteID, Name, Criticality, RelevantComputerCount:")
+		if _, err := fmt.Fscanf(stdin, "%d %s %s %d\n", &siteID, &name, &criticality, &relevantComputerCount); err != nil {
+			return Entry{}, err
+		}
+		fixletID = NextFxiletID(entries)
+	}
+	if opts.NormalizeNames {
+		name = NormalizeName(name)
+	}
+	return newEntry(entries, siteID, fixletID, name, criticality, relevantComputerCount)
+}
+
+// AddEntryFromArgs appends a new entry built from the given values without
+// reading from stdin. A fxiletID of 0 assigns the next free FixletID; any
+// other value must not already be in use.
+func AddEntryFromArgs(entries []Entry, siteID, fxiletID int, name, criticality string, computers int) ([]Entry, error) {
+	e, err := newEntry(entries, siteID, fxiletID, name, criticality, computers)
+	if err != nil {
+		return entries, err
+	}
+	return append(entries, e), nil
+}
+
+// newEntry validates the values of a new entry as described for
+// AddEntryFromArgs and returns the entry they make up.
+func newEntry(entries []Entry, siteID, fxiletID int, name, criticality string, computers int) (Entry, error) {
+	if err := ValidateSiteIDRange(siteID, 1, MaxSiteID); err != nil {
+		return Entry{}, err
+	}
+	if fxiletID < 0 {
+		return Entry{}, fmt.Errorf("invalid FixletID %d: must be positive", fxiletID)
+	}
+	if fxiletID == 0 {
+		fxiletID = NextFxiletID(entries)
+	} else if hasFixletID(entries, fxiletID) {
+		return Entry{}, fmt.Errorf("%w: %d", ErrDuplicateFxiletID, fxiletID)
+	}
+	if strings.TrimSpace(name) == "" {
+		return Entry{}, errors.New("name must not be empty")
+	}
+	if err := ValidateCriticality(criticality); err != nil {
+		return Entry{}, err
+	}
+	criticality, _ = canonicalCriticality(criticality)
+	if computers < 0 {
+		return Entry{}, fmt.Errorf("invalid RelevantComputerCount %d: must not be negative", computers)
+	}
+	return Entry{siteID, fxiletID, name, criticality, computers, ""}, nil
+}
+
+// AddEntryFromJSON appends the entry described by a JSON object such as
+// {"SiteID":1,"FxiletID":99,"Name":"Foo","Criticality":"High","RelevantComputerCount":50},
+// validating it as AddEntryFromArgs does. Keys are field names in any letter
+// case, including the FxiletID and Computers spellings; a missing FixletID
+// assigns the next free one.
+func AddEntryFromJSON(entries []Entry, jsonStr string) ([]Entry, error) {
+	e, err := parseEntryJSON(jsonStr)
+	if err != nil {
+		return entries, err
+	}
+	return AddEntryFromArgs(entries, e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
+}
+
+// parseEntryJSON decodes a JSON object with Entry fields as described for
+// AddEntryFromJSON.
+func parseEntryJSON(jsonStr string) (Entry, error) {
+	var e Entry
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonStr), &fields); err != nil {
+		return e, fmt.Errorf("invalid entry JSON: %w", err)
+	}
+	for key, raw := range fields {
+		field, ok := CanonicalField(key)
+		if !ok {
+			return e, fmt.Errorf("invalid entry JSON: unknown field %q", key)
+		}
+		var target any
+		switch field {
+		case "SiteID":
+			target = &e.SiteID
+		case "FixletID":
+			target = &e.FixletID
+		case "Name":
+			target = &e.Name
+		case "Criticality":
+			target = &e.Criticality
+		case "RelevantComputerCount":
+			target = &e.RelevantComputerCount
+		case "Notes":
+			target = &e.Notes
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			return e, fmt.Errorf("invalid entry JSON: %s: %w", key, err)
+		}
+	}
+	return e, nil
+}
+
+// CopyEntry appends a copy of the entry with FixletID srcFxiletID under
+// newFxiletID, or under the next free FixletID when newFxiletID is 0.
+func CopyEntry(entries []Entry, srcFxiletID, newFxiletID int) ([]Entry, error) {
+	src, found := GetEntry(entries, srcFxiletID)
+	if !found {
+		return entries, fmt.Errorf("%w: FixletID %d", ErrNotFound, srcFxiletID)
+	}
+	if newFxiletID == 0 {
+		newFxiletID = NextFxiletID(entries)
+	} else if hasFixletID(entries, newFxiletID) {
+		return entries, fmt.Errorf("%w: %d", ErrDuplicateFxiletID, newFxiletID)
+	}
+	clone := *src
+	clone.FixletID = newFxiletID
+	return append(entries, clone), nil
+}
+
+// fixtureAdjectives and fixtureNouns are combined into the names of
+// generated entries.
+var (
+	fixtureAdjectives = []string{"Cumulative", "Critical", "Silent", "Rapid", "Legacy", "Secure", "Remote", "Stable", "Hidden", "Modern", "Shared", "Trusted"}
+	fixtureNouns      = []string{"Kernel", "Browser", "Driver", "Runtime", "Firmware", "Gateway", "Agent", "Shell", "Library", "Service", "Database", "Compiler"}
+)
+
+// GenerateFixtures returns n synthetic entries. The values are pseudo-random
+// but fully determined by seed, so the same seed always yields the same data.
+// FixletIDs are unique and consecutive.
+func GenerateFixtures(n int, seed int64) []Entry {
+	r := rand.New(rand.NewSource(seed))
+	entries := make([]Entry, n)
+	firstID := 1000000 + r.Intn(9000000)
+	for i := range entries {
+		entries[i] = Entry{
+			SiteID:                1 + r.Intn(20),
+			FixletID:              firstID + i,
+			Name:                  fmt.Sprintf("%s %s Update KB%07d", fixtureAdjectives[r.Intn(len(fixtureAdjectives))], fixtureNouns[r.Intn(len(fixtureNouns))], r.Intn(10000000)),
+			Criticality:           AllowedCriticalities[r.Intn(len(AllowedCriticalities))],
+			RelevantComputerCount: r.Intn(1000),
+		}
+	}
+	return entries
+}
+
+// writeFixtures generates opts.Count entries from opts.Seed and writes
+// them to filename, which must not exist unless opts.Overwrite is set.
+func writeFixtures(filename string, opts Options) error {
+	if opts.Count < 0 {
+		return fmt.Errorf("invalid count %d", opts.Count)
+	}
+	if _, err := os.Stat(filename); err == nil && !opts.Overwrite {
+		return fmt.Errorf("%s already exists; use --overwrite to replace it", filename)
+	}
+	return WriteCSV(filename, GenerateFixtures(opts.Count, opts.Seed), opts.CSV)
+}
+
+// GetEntry returns a pointer to the entry with the given FixletID.
+func GetEntry(entries []Entry, fixletID int) (*Entry, bool) {
+	for i := range entries {
+		if entries[i].FixletID == fixletID {
+			return &entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// FirstEntry returns a pointer to the first entry in file order.
+func FirstEntry(entries []Entry) (*Entry, bool) {
+	if len(entries) == 0 {
+		return nil, false
+	}
+	return &entries[0], true
+}
+
+// LastEntry returns a pointer to the last entry in file order.
+func LastEntry(entries []Entry) (*Entry, bool) {
+	if len(entries) == 0 {
+		return nil, false
+	}
+	return &entries[len(entries)-1], true
+}
+
+// PrintEntryDetails writes e to w as one "key: value" pair per line.
+func PrintEntryDetails(e Entry, w io.Writer) {
+	for i, value := range entryRecord(e) {
+		fmt.Fprintf(w, "%s: %s\n", FieldNames[i], value)
+	}
+	if e.Notes != "" {
+		fmt.Fprintf(w, "Notes: %s\n", e.Notes)
+	}
+}
+
+// EntryIndex wraps a slice of entries with a map from FixletID to slice
+// position for constant-time lookups, additions and deletions. When FixletIDs
+// are duplicated, the index points at the first occurrence.
+type EntryIndex struct {
+	entries []Entry
+	byID    map[int]int
+	// dups holds the later positions of duplicated FixletIDs, in order.
+	dups map[int][]int
+	// deleted marks positions removed by Delete; Entries drops them.
+	deleted map[int]bool
+}
+
+// NewEntryIndex builds an index over entries. The index takes ownership of
+// the slice.
+func NewEntryIndex(entries []Entry) *EntryIndex {
+	idx := &EntryIndex{entries: entries, byID: make(map[int]int, len(entries)), dups: make(map[int][]int), deleted: make(map[int]bool)}
+	for i, e := range entries {
+		if _, seen := idx.byID[e.FixletID]; seen {
+			idx.dups[e.FixletID] = append(idx.dups[e.FixletID], i)
+		} else {
+			idx.byID[e.FixletID] = i
+		}
+	}
+	return idx
+}
+
+// Entries returns the indexed entries in their original order. After a
+// Delete the remaining entries are copied into a new slice, so slices
+// returned earlier keep the deleted entries.
+func (idx *EntryIndex) Entries() []Entry {
+	if len(idx.deleted) > 0 {
+		kept := make([]Entry, 0, len(idx.entries)-len(idx.deleted))
+		for i, e := range idx.entries {
+			if !idx.deleted[i] {
+				kept = append(kept, e)
+			}
+		}
+		*idx = *NewEntryIndex(kept)
+	}
+	return idx.entries
+}
+
+// holds reports whether idx was built over entries and still matches them:
+// the same slice, with no deletions or additions made since.
+func (idx *EntryIndex) holds(entries []Entry) bool {
+	if len(idx.deleted) > 0 || len(idx.entries) != len(entries) {
+		return false
+	}
+	return len(entries) == 0 || &idx.entries[0] == &entries[0]
+}
+
+// Get returns a pointer to the entry with the given FixletID.
+func (idx *EntryIndex) Get(fixletID int) (*Entry, bool) {
+	i, ok := idx.byID[fixletID]
+	if !ok {
+		return nil, false
+	}
+	return &idx.entries[i], true
+}
+
+// Delete removes the entry with the given FixletID, preserving the order of
+// the remaining entries. A later duplicate of the FixletID becomes the
+// indexed occurrence.
+func (idx *EntryIndex) Delete(fixletID int) bool {
+	i, ok := idx.byID[fixletID]
+	if !ok {
+		return false
+	}
+	idx.deleted[i] = true
+	if later := idx.dups[fixletID]; len(later) > 0 {
+		idx.byID[fixletID] = later[0]
+		if len(later) == 1 {
+			delete(idx.dups, fixletID)
+		} else {
+			idx.dups[fixletID] = later[1:]
+		}
+	} else {
+		delete(idx.byID, fixletID)
+	}
+	return true
+}
+
+// Add appends e, returning ErrDuplicateFxiletID if its FixletID is in use.
+func (idx *EntryIndex) Add(e Entry) error {
+	if _, exists := idx.byID[e.FixletID]; exists {
+		return fmt.Errorf("%w: %d", ErrDuplicateFxiletID, e.FixletID)
+	}
+	idx.byID[e.FixletID] = len(idx.entries)
+	idx.entries = append(idx.entries, e)
+	return nil
+}
+
+// DeleteEntry deletes an entry by FixletID.
+func DeleteEntry(entries []Entry, fixletID int) ([]Entry, bool) {
+	for i, e := range entries {
+		if e.FixletID == fixletID {
+			entries = append(entries[:i], entries[i+1:]...)
+			return entries, true
+		}
+	}
+	return entries, false
+}
+
+// DeleteByFilter removes every entry that satisfies pred and returns the
+// remaining entries along with the number removed. The input is not modified.
+func DeleteByFilter(entries []Entry, pred func(Entry) bool) ([]Entry, int) {
+	kept := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if !pred(e) {
+			kept = append(kept, e)
+		}
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// FindSingletonSites returns, in ascending order, the SiteIDs that only one
+// entry belongs to. Such sites often point to a mis-mapped fixlet.
+func FindSingletonSites(entries []Entry) []int {
+	var sites []int
+	for id, group := range GroupBySiteID(entries) {
+		if len(group) == 1 {
+			sites = append(sites, id)
+		}
+	}
+	slices.Sort(sites)
+	return sites
+}
+
+// printOrphanSites lists each singleton site with its only fixlet.
+func printOrphanSites(entries []Entry, sites []int) {
+	if len(sites) == 0 {
+		fmt.Println("No orphan sites.")
+		return
+	}
+	groups := GroupBySiteID(entries)
+	for _, id := range sites {
+		fmt.Printf("SiteID %d: %s\n", id, formatEntry(groups[id][0]))
+	}
+	fmt.Printf("%d orphan sites.\n", len(sites))
+}
+
+// deleteOrphans asks for confirmation and removes the entries of sites,
+// reporting whether anything was deleted.
+func deleteOrphans(entries []Entry, sites []int) ([]Entry, bool) {
+	if len(sites) == 0 || !confirm(fmt.Sprintf("Delete the %d entries of these sites?", len(sites))) {
+		return entries, false
+	}
+	kept, _ := DeleteByFilter(entries, func(e Entry) bool { return slices.Contains(sites, e.SiteID) })
+	return kept, true
+}
+
+// confirm prints a yes/no question and reports whether the user answered yes.
+func confirm(question string) bool {
+	fmt.Printf("%s (y/n): ", question)
+	answer, _ := readLine()
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+// RenameSiteID changes the SiteID of every entry with oldID to newID and
+// returns the result along with the number of entries changed. The input is
+// not modified.
+func RenameSiteID(entries []Entry, oldID, newID int) ([]Entry, int) {
+	renamed := slices.Clone(entries)
+	n := 0
+	for i := range renamed {
+		if renamed[i].SiteID == oldID {
+			renamed[i].SiteID = newID
+			n++
+		}
+	}
+	return renamed, n
+}
+
+// ReplaceInName replaces every occurrence of old with new in the Name of each
+// entry and returns the result along with the number of entries changed. The
+// input is not modified.
+func ReplaceInName(entries []Entry, old, new string, caseSensitive bool) ([]Entry, int) {
+	if old == "" {
+		return slices.Clone(entries), 0
+	}
+	if !caseSensitive {
+		re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(old))
+		return replaceNames(entries, func(name string) string { return re.ReplaceAllLiteralString(name, new) })
+	}
+	return replaceNames(entries, func(name string) string { return strings.ReplaceAll(name, old, new) })
+}
+
+// ReplaceNameRegex replaces the matches of pattern in the Name of each entry
+// with repl, which may refer to submatches as $1 or ${name}. It returns the
+// result along with the number of entries changed; the input is not modified.
+func ReplaceNameRegex(entries []Entry, pattern, repl string) ([]Entry, int, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid pattern: %w", err)
+	}
+	replaced, n := replaceNames(entries, func(name string) string { return re.ReplaceAllString(name, repl) })
+	return replaced, n, nil
+}
+
+// TrimEntries removes leading and trailing whitespace from the Name and
+// Criticality of every entry and returns the result along with the number of
+// entries changed. The input is not modified.
+func TrimEntries(entries []Entry) ([]Entry, int) {
+	trimmed := slices.Clone(entries)
+	n := 0
+	for i, e := range trimmed {
+		if e = trimEntry(e); e != trimmed[i] {
+			trimmed[i] = e
+			n++
+		}
+	}
+	return trimmed, n
+}
+
+// trimEntry removes leading and trailing whitespace from the Name and
+// Criticality of e.
+func trimEntry(e Entry) Entry {
+	e.Name = strings.TrimSpace(e.Name)
+	e.Criticality = strings.TrimSpace(e.Criticality)
+	return e
+}
+
+// replaceNames applies replace to the Name of a copy of every entry.
+func replaceNames(entries []Entry, replace func(string) string) ([]Entry, int) {
+	replaced := slices.Clone(entries)
+	n := 0
+	for i := range replaced {
+		if name := replace(replaced[i].Name); name != replaced[i].Name {
+			replaced[i].Name = name
+			n++
+		}
+	}
+	return replaced, n
+}
+
+// FieldChange records the old and new value of one changed field.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// FieldDiff lists the fields that differ between two versions of an entry,
+// in CSV column order.
+type FieldDiff []FieldChange
+
+// CompareEntries returns the fields whose values differ between old and new.
+func CompareEntries(old, new Entry) FieldDiff {
+	var diff FieldDiff
+	for _, field := range allFields {
+		if o, n := fieldString(old, field), fieldString(new, field); o != n {
+			diff = append(diff, FieldChange{field, o, n})
+		}
+	}
+	return diff
+}
+
+// String formats the diff as one "Field: old -> new" line per change.
+func (d FieldDiff) String() string {
+	var b strings.Builder
+	for _, c := range d {
+		fmt.Fprintf(&b, "%s: %s -> %s\n", c.Field, c.Old, c.New)
+	}
+	return b.String()
+}
+
+// UpdateEntry replaces the entry with the given FixletID and returns the
+// fields that changed. The entry keeps its Notes when updated has none. It
+// returns an error, leaving entries unchanged, if updated has an invalid
+// Criticality or SiteID. Only the slice is changed; callers save it with
+// WriteCSV, or saveEntries, to persist the update.
+func UpdateEntry(entries []Entry, fixletID int, updated Entry) ([]Entry, FieldDiff, bool, error) {
+	if err := ValidateCriticality(updated.Criticality); err != nil {
+		return entries, nil, false, err
+	}
+	if err := ValidateSiteIDRange(updated.SiteID, 1, MaxSiteID); err != nil {
+		return entries, nil, false, err
+	}
+	updated.Criticality, _ = canonicalCriticality(updated.Criticality)
+	for i, e := range entries {
+		if e.FixletID == fixletID {
+			if updated.Notes == "" {
+				updated.Notes = e.Notes
+			}
+			entries[i] = updated
+			return entries, CompareEntries(e, updated), true, nil
+		}
+	}
+	return entries, nil, false, nil
+}
+
+// UpsertResult reports what UpsertEntry did.
+type UpsertResult struct {
+	Inserted bool
+	Updated  bool
+	// FieldDiff lists the fields an update changed.
+	FieldDiff FieldDiff
+}
+
+// UpsertEntry replaces the entry with the FixletID of e, or appends e if
+// there is none, validating it as UpdateEntry and AddEntryFromArgs do.
+// A FixletID of 0 always inserts, with the next free FixletID. Replacing an
+// entry with an identical one sets Updated with an empty FieldDiff.
+func UpsertEntry(entries []Entry, e Entry) ([]Entry, UpsertResult, error) {
+	if e.FixletID != 0 && hasFixletID(entries, e.FixletID) {
+		updated, diff, _, err := UpdateEntry(entries, e.FixletID, e)
+		if err != nil {
+			return entries, UpsertResult{}, err
+		}
+		return updated, UpsertResult{Updated: true, FieldDiff: diff}, nil
+	}
+	inserted, err := newEntry(entries, e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
+	if err != nil {
+		return entries, UpsertResult{}, err
+	}
+	inserted.Notes = e.Notes
+	return append(entries, inserted), UpsertResult{Inserted: true}, nil
+}
+
+// printUpsertResult tells the user whether an entry was added or which of
+// its fields were updated.
+func printUpsertResult(result UpsertResult) {
+	switch {
+	case result.Inserted:
+		info("Entry added.")
+	case len(result.FieldDiff) == 0:
+		info("Entry unchanged.")
+	default:
+		infof("Entry updated:\n%s", result.FieldDiff)
+	}
+}
+
+// AnnotateEntry replaces the notes of the entry with the given FixletID; an
+// empty note removes them. It reports whether the entry was found.
+func AnnotateEntry(entries []Entry, fixletID int, note string) ([]Entry, bool) {
+	for i := range entries {
+		if entries[i].FixletID == fixletID {
+			entries[i].Notes = note
+			return entries, true
+		}
+	}
+	return entries, false
+}
+
+// PatchEntry updates the entry with the given FixletID, only overwriting
+// fields that are non-zero or non-empty in patch, and returns the fields
+// that changed. A new FixletID already used by another entry is rejected
+// with ErrDuplicateFxiletID.
+func PatchEntry(entries []Entry, fixletID int, patch Entry) ([]Entry, FieldDiff, bool, error) {
+	if patch.Criticality != "" {
+		if err := ValidateCriticality(patch.Criticality); err != nil {
+			return entries, nil, false, err
+		}
+		patch.Criticality, _ = canonicalCriticality(patch.Criticality)
+	}
+	if patch.SiteID != 0 {
+		if err := ValidateSiteIDRange(patch.SiteID, 1, MaxSiteID); err != nil {
+			return entries, nil, false, err
+		}
+	}
+	if patch.FixletID < 0 {
+		return entries, nil, false, fmt.Errorf("invalid FixletID %d: must be positive", patch.FixletID)
+	}
+	if patch.RelevantComputerCount < 0 {
+		return entries, nil, false, fmt.Errorf("invalid RelevantComputerCount %d: must not be negative", patch.RelevantComputerCount)
+	}
+	for i, old := range entries {
+		if old.FixletID != fixletID {
+			continue
+		}
+		if patch.FixletID != 0 && patch.FixletID != fixletID && hasFixletID(entries, patch.FixletID) {
+			return entries, nil, true, fmt.Errorf("%w: %d", ErrDuplicateFxiletID, patch.FixletID)
+		}
+		e := old
+		if patch.SiteID != 0 {
+			e.SiteID = patch.SiteID
+		}
+		if patch.FixletID != 0 {
+			e.FixletID = patch.FixletID
+		}
+		if patch.Name != "" {
+			e.Name = patch.Name
+		}
+		if patch.Criticality != "" {
+			e.Criticality = patch.Criticality
+		}
+		if patch.Notes != "" {
+			e.Notes = patch.Notes
+		}
+		if patch.RelevantComputerCount != 0 {
+			e.RelevantComputerCount = patch.RelevantComputerCount
+		}
+		entries[i] = e
+		return entries, CompareEntries(old, e), true, nil
+	}
+	return entries, nil, false, nil
+}
+
+// FieldFrequency counts how many entries have each distinct value of field.
+// It is CountByField under the name of the freq command.
+func FieldFrequency(entries []Entry, field string) (map[string]int, error) {
+	return CountByField(entries, field)
+}
+
+// CountByField groups entries by the value of field, numbers written in
+// decimal, and counts each group.
+func CountByField(entries []Entry, field string) (map[string]int, error) {
+	name, ok := CanonicalField(field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[fieldString(e, name)]++
+	}
+	return counts, nil
+}
+
+// Bucket is an inclusive range of numeric field values. An Open bucket has
+// no upper bound.
+type Bucket struct {
+	Min, Max int
+	Open     bool
+}
+
+func (b Bucket) String() string {
+	if b.Open {
+		return fmt.Sprintf("%d+", b.Min)
+	}
+	return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+// ParseBuckets parses a comma-separated list of ranges such as
+// "0-100,101-500,501+".
+func ParseBuckets(spec string) ([]Bucket, error) {
+	var buckets []Bucket
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		var b Bucket
+		var err error
+		if lo, ok := strings.CutSuffix(part, "+"); ok {
+			b.Open = true
+			b.Min, err = strconv.Atoi(lo)
+		} else if lo, hi, ok := strings.Cut(part, "-"); ok {
+			if b.Min, err = strconv.Atoi(lo); err == nil {
+				b.Max, err = strconv.Atoi(hi)
+			}
+			if err == nil && b.Max < b.Min {
+				err = errors.New("upper bound is below lower bound")
+			}
+		} else {
+			err = errors.New("expected min-max or min+")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", part, err)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// FieldFrequencyBuckets counts how many entries have a value of the numeric
+// field in each bucket, keyed by the bucket's String form. Values outside
+// every bucket are counted under "other"; a value in overlapping buckets is
+// counted in the first.
+func FieldFrequencyBuckets(entries []Entry, field string, buckets []Bucket) (map[string]int, error) {
+	name, ok := CanonicalField(field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	if !isNumericField(name) {
+		return nil, fmt.Errorf("field %s is not numeric", name)
+	}
+	freq := make(map[string]int)
+	for _, e := range entries {
+		v := fieldInt(e, name)
+		label := "other"
+		for _, b := range buckets {
+			if v >= b.Min && (b.Open || v <= b.Max) {
+				label = b.String()
+				break
+			}
+		}
+		freq[label]++
+	}
+	return freq, nil
+}
+
+// PrintFrequency writes freq to w as a table, highest count first.
+func PrintFrequency(freq map[string]int, w io.Writer) {
+	values := slices.Collect(maps.Keys(freq))
+	sort.Slice(values, func(i, j int) bool {
+		if freq[values[i]] != freq[values[j]] {
+			return freq[values[i]] > freq[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	rows := make([][]string, len(values))
+	for i, v := range values {
+		rows[i] = []string{v, strconv.Itoa(freq[v])}
+	}
+	writeTable(w, []string{"Value", "Count"}, rows)
+}
+
+// CrossTabResult counts entries by SiteID and then by Criticality.
+type CrossTabResult map[int]map[string]int
+
+// CrossTab counts the entries of every SiteID at each criticality level.
+func CrossTab(entries []Entry) CrossTabResult {
+	ct := make(CrossTabResult)
+	for _, e := range entries {
+		if ct[e.SiteID] == nil {
+			ct[e.SiteID] = make(map[string]int)
+		}
+		ct[e.SiteID][e.Criticality]++
+	}
+	return ct
+}
+
+// PrintCrossTab writes ct to w as a grid with one row per SiteID and one
+// column per criticality level, plus totals. Levels are ordered as in
+// AllowedCriticalities, followed by any others alphabetically.
+func PrintCrossTab(ct CrossTabResult, w io.Writer) {
+	levels := crossTabLevels(ct)
+	headers := append(append([]string{"SiteID"}, levels...), "Total")
+	var rows [][]string
+	columnTotals := make([]int, len(levels))
+	total := 0
+	for _, site := range slices.Sorted(maps.Keys(ct)) {
+		row := []string{strconv.Itoa(site)}
+		siteTotal := 0
+		for i, c := range levels {
+			n := ct[site][c]
+			row = append(row, strconv.Itoa(n))
+			siteTotal += n
+			columnTotals[i] += n
+		}
+		rows = append(rows, append(row, strconv.Itoa(siteTotal)))
+		total += siteTotal
+	}
+	totalRow := []string{"Total"}
+	for _, n := range columnTotals {
+		totalRow = append(totalRow, strconv.Itoa(n))
+	}
+	rows = append(rows, append(totalRow, strconv.Itoa(total)))
+	writeTable(w, headers, rows)
+}
+
+// crossTabLevels returns the criticality levels that occur in ct, ordered as
+// in AllowedCriticalities, followed by any others alphabetically.
+func crossTabLevels(ct CrossTabResult) []string {
+	present := make(map[string]bool)
+	for _, counts := range ct {
+		for c := range counts {
+			present[c] = true
+		}
+	}
+	var levels []string
+	for _, c := range AllowedCriticalities {
+		if present[c] {
+			levels = append(levels, c)
+			delete(present, c)
+		}
+	}
+	return append(levels, sortedKeys(present)...)
+}
+
+// PivotBySiteID turns entries into a wide table with one row per SiteID and
+// one column per criticality level after the SiteID column, holding the
+// number of fixlets at that level. Unlike PrintCrossTab it has no totals, so
+// the result can be loaded into a spreadsheet as is.
+func PivotBySiteID(entries []Entry) (headers []string, rows [][]string) {
+	ct := CrossTab(entries)
+	levels := crossTabLevels(ct)
+	headers = append([]string{"SiteID"}, levels...)
+	for _, site := range slices.Sorted(maps.Keys(ct)) {
+		row := []string{strconv.Itoa(site)}
+		for _, c := range levels {
+			row = append(row, strconv.Itoa(ct[site][c]))
+		}
+		rows = append(rows, row)
+	}
+	return headers, rows
+}
+
+// WriteCSVPivot writes a table produced by PivotBySiteID to a CSV file.
+func WriteCSVPivot(filename string, headers []string, rows [][]string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return writePivotCSV(w, headers, rows)
+	})
+}
+
+// writePivotCSV writes headers and rows to w as CSV data.
+func writePivotCSV(w io.Writer, headers []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(headers)
+	return cw.WriteAll(rows)
+}
+
+// CountEntries returns the number of entries that satisfy pred. A nil pred
+// counts every entry.
+func CountEntries(entries []Entry, pred func(Entry) bool) int {
+	if pred == nil {
+		return len(entries)
+	}
+	n := 0
+	for _, e := range entries {
+		if pred(e) {
+			n++
+		}
+	}
+	return n
+}
+
+// printCriticalityBreakdown prints the number of entries per criticality level.
+func printCriticalityBreakdown(entries []Entry) {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Criticality]++
+	}
+	for _, c := range sortedKeys(counts) {
+		fmt.Printf("%s: %d\n", c, counts[c])
+	}
+	fmt.Printf("Total: %d\n", len(entries))
+}
+
+// SiteSummary totals the fixlets and relevant computers for one SiteID.
+type SiteSummary struct {
+	SiteID    int
+	Fixlets   int
+	Computers int
+}
+
+// EntryStats summarises a dataset.
+type EntryStats struct {
+	Total         int
+	ByCriticality map[string]int
+	MaxComputers  int
+	MinComputers  int
+	AvgComputers  float64
+	TopSites      []SiteSummary
+}
+
+// topSitesLimit is the number of sites reported in EntryStats.TopSites.
+const topSitesLimit = 5
+
+// Stats computes summary statistics for entries. TopSites holds the sites
+// with the most relevant computers, largest first.
+func Stats(entries []Entry) EntryStats {
+	stats := EntryStats{Total: len(entries), ByCriticality: make(map[string]int)}
+	sites := make(map[int]*SiteSummary)
+	sum := 0
+	for i, e := range entries {
+		stats.ByCriticality[e.Criticality]++
+		if i == 0 || e.RelevantComputerCount > stats.MaxComputers {
+			stats.MaxComputers = e.RelevantComputerCount
+		}
+		if i == 0 || e.RelevantComputerCount < stats.MinComputers {
+			stats.MinComputers = e.RelevantComputerCount
+		}
+		sum += e.RelevantComputerCount
+		site, ok := sites[e.SiteID]
+		if !ok {
+			site = &SiteSummary{SiteID: e.SiteID}
+			sites[e.SiteID] = site
+		}
+		site.Fixlets++
+		site.Computers += e.RelevantComputerCount
+	}
+	if len(entries) > 0 {
+		stats.AvgComputers = float64(sum) / float64(len(entries))
+	}
+	for _, site := range sites {
+		stats.TopSites = append(stats.TopSites, *site)
+	}
+	sort.Slice(stats.TopSites, func(i, j int) bool {
+		a, b := stats.TopSites[i], stats.TopSites[j]
+		if a.Computers != b.Computers {
+			return a.Computers > b.Computers
+		}
+		return a.SiteID < b.SiteID
+	})
+	if len(stats.TopSites) > topSitesLimit {
+		stats.TopSites = stats.TopSites[:topSitesLimit]
+	}
+	return stats
+}
+
+// PrintStats writes stats to w as a human-readable report or, with format
+// "json", as a JSON object.
+func PrintStats(stats EntryStats, format string, w io.Writer) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	case "", "text", "table":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	fmt.Fprintf(w, "Total entries: %d\n", stats.Total)
+	fmt.Fprintln(w, "By criticality:")
+	for _, c := range sortedKeys(stats.ByCriticality) {
+		fmt.Fprintf(w, "  %s: %d\n", c, stats.ByCriticality[c])
+	}
+	fmt.Fprintf(w, "Computers: min %d, max %d, avg %.2f\n", stats.MinComputers, stats.MaxComputers, stats.AvgComputers)
+	fmt.Fprintln(w, "Top sites:")
+	for _, site := range stats.TopSites {
+		fmt.Fprintf(w, "  SiteID %d: %d fixlets, %d computers\n", site.SiteID, site.Fixlets, site.Computers)
+	}
+	return nil
+}
+
+// FieldSummary holds descriptive statistics for one numeric field.
+type FieldSummary struct {
+	Count  int
+	Min    int
+	Max    int
+	Mean   float64
+	Median float64
+	StdDev float64 // sample standard deviation; 0 for fewer than two values
+}
+
+// NumericSummary describes every numeric field of a set of entries.
+type NumericSummary struct {
+	SiteID                FieldSummary
+	FixletID              FieldSummary
+	RelevantComputerCount FieldSummary
+}
+
+// DescribeNumeric summarizes the numeric fields of entries, in the manner of
+// pandas' DataFrame.describe.
+func DescribeNumeric(entries []Entry) NumericSummary {
+	return NumericSummary{
+		SiteID:                describeField(entries, "SiteID"),
+		FixletID:              describeField(entries, "FixletID"),
+		RelevantComputerCount: describeField(entries, "RelevantComputerCount"),
+	}
+}
+
+// describeField computes the FieldSummary of one numeric field.
+func describeField(entries []Entry, field string) FieldSummary {
+	summary := FieldSummary{Count: len(entries)}
+	if len(entries) == 0 {
+		return summary
+	}
+	values := make([]int, len(entries))
+	sum := 0.0
+	for i, e := range entries {
+		values[i] = fieldInt(e, field)
+		sum += float64(values[i])
+	}
+	slices.Sort(values)
+	n := len(values)
+	summary.Min, summary.Max = values[0], values[n-1]
+	summary.Mean = sum / float64(n)
+	if n%2 == 1 {
+		summary.Median = float64(values[n/2])
+	} else {
+		summary.Median = (float64(values[n/2-1]) + float64(values[n/2])) / 2
+	}
+	if n > 1 {
+		squares := 0.0
+		for _, v := range values {
+			d := float64(v) - summary.Mean
+			squares += d * d
+		}
+		summary.StdDev = math.Sqrt(squares / float64(n-1))
+	}
+	return summary
+}
+
+// PrintDescribe writes summary to w as a table, or as JSON when format is
+// "json".
+func PrintDescribe(summary NumericSummary, format string, w io.Writer) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	case "", "text", "table":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	var rows [][]string
+	for _, f := range []struct {
+		name string
+		s    FieldSummary
+	}{
+		{"SiteID", summary.SiteID},
+		{"FixletID", summary.FixletID},
+		{"RelevantComputerCount", summary.RelevantComputerCount},
+	} {
+		rows = append(rows, []string{
+			f.name, strconv.Itoa(f.s.Count), strconv.Itoa(f.s.Min), strconv.Itoa(f.s.Max),
+			strconv.FormatFloat(f.s.Mean, 'f', 2, 64), strconv.FormatFloat(f.s.Median, 'f', 2, 64), strconv.FormatFloat(f.s.StdDev, 'f', 2, 64),
+		})
+	}
+	writeTable(w, []string{"Field", "Count", "Min", "Max", "Mean", "Median", "StdDev"}, rows)
+	return nil
+}
+
+// SiteStats is the health report for a single SiteID.
+type SiteStats struct {
+	SiteID             int
+	TotalFixlets       int
+	ByCriticality      map[string]int
+	TotalComputers     int
+	HighestCountFixlet Entry
+}
+
+// SiteReport aggregates entries per SiteID, ordered by TotalComputers
+// descending and then by SiteID. HighestCountFixlet is the first fixlet of
+// the site with the largest RelevantComputerCount.
+func SiteReport(entries []Entry) []SiteStats {
+	bySite := make(map[int]*SiteStats)
+	var order []int
+	for _, e := range entries {
+		site, ok := bySite[e.SiteID]
+		if !ok {
+			site = &SiteStats{SiteID: e.SiteID, ByCriticality: make(map[string]int), HighestCountFixlet: e}
+			bySite[e.SiteID] = site
+			order = append(order, e.SiteID)
+		}
+		site.TotalFixlets++
+		site.ByCriticality[e.Criticality]++
+		site.TotalComputers += e.RelevantComputerCount
+		if e.RelevantComputerCount > site.HighestCountFixlet.RelevantComputerCount {
+			site.HighestCountFixlet = e
+		}
+	}
+	report := make([]SiteStats, len(order))
+	for i, id := range order {
+		report[i] = *bySite[id]
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].TotalComputers != report[j].TotalComputers {
+			return report[i].TotalComputers > report[j].TotalComputers
+		}
+		return report[i].SiteID < report[j].SiteID
+	})
+	return report
+}
+
+// PrintSiteReport writes report to w as a human-readable report or, with
+// format "json" or "csv", in a machine-readable form. The CSV form has one
+// column per criticality level present in the report.
+func PrintSiteReport(report []SiteStats, format string, w io.Writer) error {
+	switch format {
+	case "json":
+		if report == nil {
+			report = []SiteStats{}
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "csv":
+		return writeSiteReportCSV(report, w)
+	case "", "text", "table":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	if len(report) == 0 {
+		fmt.Fprintln(w, "No entries available.")
+		return nil
+	}
+	for _, site := range report {
+		fmt.Fprintf(w, "SiteID %d\n", site.SiteID)
+		fmt.Fprintf(w, "  Fixlets: %d\n", site.TotalFixlets)
+		fmt.Fprintf(w, "  Computers: %d\n", site.TotalComputers)
+		for _, c := range sortedKeys(site.ByCriticality) {
+			fmt.Fprintf(w, "  %s: %d\n", c, site.ByCriticality[c])
+		}
+		top := site.HighestCountFixlet
+		fmt.Fprintf(w, "  Highest count: FixletID %d, %s (%d computers)\n", top.FixletID, top.Name, top.RelevantComputerCount)
+	}
+	return nil
+}
+
+// writeSiteReportCSV writes report to w as CSV.
+func writeSiteReportCSV(report []SiteStats, w io.Writer) error {
+	seen := make(map[string]int)
+	for _, site := range report {
+		for c := range site.ByCriticality {
+			seen[c]++
+		}
+	}
+	levels := sortedKeys(seen)
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{"SiteID", "TotalFixlets", "TotalComputers"}, levels...), "HighestCountFixletID", "HighestCount"))
+	for _, site := range report {
+		record := []string{strconv.Itoa(site.SiteID), strconv.Itoa(site.TotalFixlets), strconv.Itoa(site.TotalComputers)}
+		for _, c := range levels {
+			record = append(record, strconv.Itoa(site.ByCriticality[c]))
+		}
+		record = append(record, strconv.Itoa(site.HighestCountFixlet.FixletID), strconv.Itoa(site.HighestCountFixlet.RelevantComputerCount))
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AuditEvent is a single line of the audit log.
+type AuditEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+	User      string    `json:"user"`
+	FxiletID  int       `json:"fxilet_id"`
+	Before    *Entry    `json:"before"`
+	After     *Entry    `json:"after"`
+	Changes   FieldDiff `json:"changes,omitempty"`
+}
+
+// AuditLogger appends AuditEvents as JSON lines to a log file.
+type AuditLogger struct {
+	Path string
+}
+
+// NewAuditLogger returns a logger that appends to path.
+func NewAuditLogger(path string) *AuditLogger {
+	return &AuditLogger{Path: path}
+}
+
+// AuditLogPath returns the audit log that belongs to a CSV file, such as
+// fixlets_audit.log for fixlets.csv.
+func AuditLogPath(csvFile string) string {
+	base, _ := splitExt(csvFile)
+	return base + "_audit.log"
+}
+
+// Log appends ev to the log, filling in the timestamp and user if unset.
+func (l *AuditLogger) Log(ev AuditEvent) error {
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now().UTC()
+	}
+	if ev.User == "" {
+		ev.User = os.Getenv("USER")
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// LogChanges logs one event for every entry that differs, by FixletID,
+// between before and after.
+func (l *AuditLogger) LogChanges(op string, before, after []Entry) error {
+	for _, ev := range changeEvents(op, before, after) {
+		if err := l.Log(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeEvents returns the events LogChanges logs, without a timestamp.
+func changeEvents(op string, before, after []Entry) []AuditEvent {
+	diff := DiffEntries(before, after)
+	var events []AuditEvent
+	for _, e := range diff.OnlyInA {
+		events = append(events, AuditEvent{Operation: op, FxiletID: e.FixletID, Before: &e})
+	}
+	for _, e := range diff.OnlyInB {
+		events = append(events, AuditEvent{Operation: op, FxiletID: e.FixletID, After: &e})
+	}
+	for _, c := range diff.Changed {
+		events = append(events, AuditEvent{Operation: op, FxiletID: c.After.FixletID, Before: &c.Before, After: &c.After, Changes: CompareEntries(c.Before, c.After)})
+	}
+	// DiffEntries only compares the first entry with each FixletID; later
+	// copies that were removed or added, as by dedup, are logged as well.
+	removed := make(map[Entry]int)
+	for _, e := range laterCopies(before) {
+		removed[e]++
+	}
+	for _, e := range laterCopies(after) {
+		if removed[e] > 0 {
+			removed[e]--
+			continue
+		}
+		events = append(events, AuditEvent{Operation: op, FxiletID: e.FixletID, After: &e})
+	}
+	for _, e := range laterCopies(before) {
+		if removed[e] > 0 {
+			removed[e]--
+			events = append(events, AuditEvent{Operation: op, FxiletID: e.FixletID, Before: &e})
+		}
+	}
+	return events
+}
+
+// laterCopies returns the entries whose FixletID appears earlier in entries.
+func laterCopies(entries []Entry) []Entry {
+	var copies []Entry
+	seen := make(map[int]bool, len(entries))
+	for _, e := range entries {
+		if seen[e.FixletID] {
+			copies = append(copies, e)
+		}
+		seen[e.FixletID] = true
+	}
+	return copies
+}
+
+// ReadAuditLog returns the last n events of the log at path, oldest first.
+// A missing log holds no events.
+func ReadAuditLog(path string, n int) ([]AuditEvent, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var ev AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		events = append(events, ev)
+		if len(events) > n {
+			events = events[1:]
+		}
+	}
+	return events, scanner.Err()
+}
+
+// PrintAuditEvents writes audit events to w with their before and after
+// values.
+func PrintAuditEvents(events []AuditEvent, w io.Writer) {
+	if len(events) == 0 {
+		fmt.Fprintln(w, "The audit log is empty.")
+		return
+	}
+	for _, ev := range events {
+		fmt.Fprintf(w, "%s  %s  %s  FixletID %d\n", ev.Timestamp.Format(time.RFC3339), ev.User, ev.Operation, ev.FxiletID)
+		if ev.Before != nil {
+			fmt.Fprintln(w, "  before: "+formatEntry(*ev.Before))
+		}
+		if ev.After != nil {
+			fmt.Fprintln(w, "  after:  "+formatEntry(*ev.After))
+		}
+		for _, c := range ev.Changes {
+			fmt.Fprintf(w, "  changed %s: %s -> %s\n", c.Field, c.Old, c.New)
+		}
+	}
+}
+
+// undoLevels is the number of snapshots kept by an UndoStack.
+const undoLevels = 10
+
+// UndoStack holds snapshots of the entries taken before mutating operations.
+// Only the most recent undoLevels snapshots are kept.
+type UndoStack struct {
+	snapshots [][]Entry
+}
+
+// Push records a snapshot. The caller must not modify it afterwards.
+func (u *UndoStack) Push(snapshot []Entry) {
+	u.snapshots = append(u.snapshots, snapshot)
+	if len(u.snapshots) > undoLevels {
+		u.snapshots = u.snapshots[len(u.snapshots)-undoLevels:]
+	}
+}
+
+// Pop removes and returns the most recent snapshot.
+func (u *UndoStack) Pop() ([]Entry, bool) {
+	if len(u.snapshots) == 0 {
+		return nil, false
+	}
+	snapshot := u.snapshots[len(u.snapshots)-1]
+	u.snapshots = u.snapshots[:len(u.snapshots)-1]
+	return snapshot, true
+}
+
+// Clear discards every snapshot.
+func (u *UndoStack) Clear() {
+	u.snapshots = nil
+}
+
+// queryHistorySize is how many search terms QueryHistory keeps in memory,
+// and queryHistoryFileSize how many are kept in the history file.
+const (
+	queryHistorySize     = 20
+	queryHistoryFileSize = 100
+)
+
+// QueryHistory is a ring buffer of the most recent search terms of a
+// session, oldest first.
+type QueryHistory struct {
+	items [queryHistorySize]string
+	start int
+	n     int
+}
+
+// Add records term, overwriting the oldest term when the history is full.
+// Empty terms and repeats of the latest term are not recorded.
+func (h *QueryHistory) Add(term string) {
+	if term == "" || (h.n > 0 && h.Get(h.n) == term) {
+		return
+	}
+	if h.n < len(h.items) {
+		h.items[(h.start+h.n)%len(h.items)] = term
+		h.n++
+		return
+	}
+	h.items[h.start] = term
+	h.start = (h.start + 1) % len(h.items)
+}
+
+// Get returns the i-th term, counting from 1 for the oldest.
+func (h *QueryHistory) Get(i int) string {
+	return h.items[(h.start+i-1)%len(h.items)]
+}
+
+// Items returns the terms, oldest first.
+func (h *QueryHistory) Items() []string {
+	items := make([]string, h.n)
+	for i := range items {
+		items[i] = h.Get(i + 1)
+	}
+	return items
+}
+
+// Recall expands a reference of the form "!N" to the N-th term. A leading
+// "!!" stands for a literal "!", so "!!1" is the term "!1". Any other term
+// is returned as it is.
+func (h *QueryHistory) Recall(term string) (string, error) {
+	ref, ok := strings.CutPrefix(term, "!")
+	if !ok {
+		return term, nil
+	}
+	if strings.HasPrefix(ref, "!") {
+		return ref, nil
+	}
+	i, err := strconv.Atoi(ref)
+	if err != nil || i < 1 || i > h.n {
+		return "", fmt.Errorf("no history item %s", term)
+	}
+	return h.Get(i), nil
+}
+
+// PrintQueryHistory lists the terms in h with the numbers Recall accepts.
+func PrintQueryHistory(h *QueryHistory, w io.Writer) {
+	items := h.Items()
+	if len(items) == 0 {
+		fmt.Fprintln(w, "No queries yet.")
+		return
+	}
+	for i, term := range items {
+		fmt.Fprintf(w, "%3d  %s\n", i+1, term)
+	}
+}
+
+// DefaultQueryHistoryPath returns the path of the per-user query history,
+// ~/.fixlets/query_history.
+func DefaultQueryHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".fixlets", "query_history")
+	}
+	return filepath.Join(home, ".fixlets", "query_history")
+}
+
+// LoadQueryHistory reads the history file at path, one term per line, into
+// a QueryHistory holding its most recent terms. A missing file holds none.
+func LoadQueryHistory(path string) (*QueryHistory, error) {
+	h := new(QueryHistory)
+	lines, err := readHistoryFile(path)
+	for _, line := range lines {
+		h.Add(line)
+	}
+	return h, err
+}
+
+// AppendQueryHistory adds term to the end of the history file at path,
+// keeping only its last queryHistoryFileSize terms. Like QueryHistory.Add,
+// it does not record a repeat of the latest term.
+func AppendQueryHistory(path, term string) error {
+	lines, err := readHistoryFile(path)
+	if err != nil {
+		return err
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == term {
+		return nil
+	}
+	lines = append(lines, term)
+	if len(lines) > queryHistoryFileSize {
+		lines = lines[len(lines)-queryHistoryFileSize:]
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+		return err
+	})
+}
+
+// readHistoryFile returns the non-empty lines of the history file at path.
+func readHistoryFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// Config holds persistent settings loaded from a config file. Zero values
+// mean the setting is not configured.
+type Config struct {
+	CSVFile              string   `json:"csv_file,omitempty"`
+	DefaultPageSize      int      `json:"default_page_size,omitempty"`
+	AutoBackup           bool     `json:"auto_backup,omitempty"`
+	Delimiter            string   `json:"delimiter,omitempty"`
+	AllowedCriticalities []string `json:"allowed_criticalities,omitempty"`
+	MaxSiteID            int      `json:"max_site_id,omitempty"`
+	LockTimeout          int      `json:"lock_timeout,omitempty"` // seconds
+	ProgressThresholdMB  int      `json:"progress_threshold_mb,omitempty"`
+}
+
+// DefaultConfigPath returns the path of the per-user config file, ~/.fixlets.toml.
+func DefaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".fixlets.toml"
+	}
+	return filepath.Join(home, ".fixlets.toml")
+}
+
+// LoadConfig reads a config file written in a small subset of TOML: one
+// "key = value" pair per line, where a value is a quoted string, an integer,
+// a boolean or an array of quoted strings. Lines starting with # are comments.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return cfg, fmt.Errorf("%s:%d: expected key = value", path, n+1)
+		}
+		key = strings.TrimSpace(key)
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return cfg, fmt.Errorf("%s:%d: %s: %w", path, n+1, key, err)
+		}
+		if err := cfg.set(key, value); err != nil {
+			return cfg, fmt.Errorf("%s:%d: %w", path, n+1, err)
+		}
+	}
+	return cfg, nil
+}
+
+// set assigns a parsed value to the config field named by key.
+func (c *Config) set(key string, value any) error {
+	var ok bool
+	switch key {
+	case "csv_file":
+		c.CSVFile, ok = value.(string)
+	case "default_page_size":
+		c.DefaultPageSize, ok = value.(int)
+	case "auto_backup":
+		c.AutoBackup, ok = value.(bool)
+	case "delimiter":
+		c.Delimiter, ok = value.(string)
+	case "allowed_criticalities":
+		c.AllowedCriticalities, ok = value.([]string)
+	case "max_site_id":
+		c.MaxSiteID, ok = value.(int)
+	case "lock_timeout":
+		c.LockTimeout, ok = value.(int)
+	case "progress_threshold_mb":
+		c.ProgressThresholdMB, ok = value.(int)
+	default:
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	if !ok {
+		return fmt.Errorf("%s has the wrong type", key)
+	}
+	return nil
+}
+
+// parseConfigValue parses a single config value, ignoring a trailing comment.
+func parseConfigValue(raw string) (any, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		quoted, err := strconv.QuotedPrefix(raw)
+		if err != nil {
+			return nil, err
+		}
+		if rest := strings.TrimSpace(raw[len(quoted):]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return strconv.Unquote(quoted)
+	case strings.HasPrefix(raw, "["):
+		var items []string
+		rest := strings.TrimSpace(raw[1:])
+		for !strings.HasPrefix(rest, "]") {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("arrays may only hold quoted strings")
+			}
+			item, _ := strconv.Unquote(quoted)
+			items = append(items, item)
+			rest = strings.TrimSpace(rest[len(quoted):])
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, fmt.Errorf("expected , or ] in array")
+			}
+		}
+		if rest = strings.TrimSpace(rest[1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return items, nil
+	}
+	raw, _, _ = strings.Cut(raw, "#")
+	raw = strings.TrimSpace(raw)
+	switch raw {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, err := strconv.Atoi(raw); err == nil {
+		return n, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", raw)
+}
+
+// PrintConfig writes cfg to w in the config file format.
+func PrintConfig(cfg Config, w io.Writer) {
+	quoted := make([]string, len(cfg.AllowedCriticalities))
+	for i, c := range cfg.AllowedCriticalities {
+		quoted[i] = strconv.Quote(c)
+	}
+	fmt.Fprintf(w, "csv_file = %q\n", cfg.CSVFile)
+	fmt.Fprintf(w, "default_page_size = %d\n", cfg.DefaultPageSize)
+	fmt.Fprintf(w, "auto_backup = %t\n", cfg.AutoBackup)
+	fmt.Fprintf(w, "delimiter = %q\n", cfg.Delimiter)
+	fmt.Fprintf(w, "allowed_criticalities = [%s]\n", strings.Join(quoted, ", "))
+	fmt.Fprintf(w, "max_site_id = %d\n", cfg.MaxSiteID)
+	fmt.Fprintf(w, "lock_timeout = %d\n", cfg.LockTimeout)
+	fmt.Fprintf(w, "progress_threshold_mb = %d\n", cfg.ProgressThresholdMB)
+}
+
+// activeConfig describes the settings in effect for opts.
+func activeConfig(opts Options) Config {
+	delimiter := string(opts.CSV.Delimiter)
+	if opts.CSV.Delimiter == '\t' {
+		delimiter = "tab"
+	}
+	return Config{
+		CSVFile:              opts.File,
+		DefaultPageSize:      opts.PageSize,
+		AutoBackup:           opts.Backup,
+		Delimiter:            delimiter,
+		AllowedCriticalities: AllowedCriticalities,
+		MaxSiteID:            MaxSiteID,
+		LockTimeout:          int(LockTimeout / time.Second),
+		ProgressThresholdMB:  opts.ProgressMB,
+	}
+}
+
+// applyConfig copies the configured settings of cfg into opts, except for
+// the settings, named after their flags, for which pinned returns true.
+func applyConfig(opts *Options, cfg Config, pinned func(flag string) bool) error {
+	if cfg.CSVFile != "" && !pinned("file") {
+		opts.File = cfg.CSVFile
+	}
+	if cfg.DefaultPageSize > 0 {
+		opts.PageSize = cfg.DefaultPageSize
+	}
+	if cfg.AutoBackup && !pinned("backup") {
+		opts.Backup = true
+	}
+	if cfg.Delimiter != "" && !pinned("delimiter") {
+		delimiter, err := ParseDelimiter(cfg.Delimiter)
+		if err != nil {
+			return err
+		}
+		opts.CSV.Delimiter = delimiter
+	}
+	if len(cfg.AllowedCriticalities) > 0 {
+		AllowedCriticalities = cfg.AllowedCriticalities
+	}
+	if cfg.MaxSiteID > 0 {
+		MaxSiteID = cfg.MaxSiteID
+	}
+	if cfg.LockTimeout > 0 && !pinned("lock-timeout") {
+		LockTimeout = time.Duration(cfg.LockTimeout) * time.Second
+	}
+	if cfg.ProgressThresholdMB > 0 && !pinned("progress-threshold-mb") {
+		opts.ProgressMB = cfg.ProgressThresholdMB
+	}
+	return nil
+}
+
+// ProfileStore holds named profiles, each a CSV file plus settings that
+// override the config file, and the name of the active profile.
+type ProfileStore struct {
+	Active   string            `json:"active,omitempty"`
+	Profiles map[string]Config `json:"profiles"`
+}
+
+// DefaultProfilesPath returns the path of the per-user profile store,
+// ~/.fixlets/profiles.json.
+func DefaultProfilesPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".fixlets", "profiles.json")
+	}
+	return filepath.Join(home, ".fixlets", "profiles.json")
+}
+
+// LoadProfiles reads the profile store at path. A missing store holds no
+// profiles.
+func LoadProfiles(path string) (ProfileStore, error) {
+	store := ProfileStore{Profiles: make(map[string]Config)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return store, fmt.Errorf("%s: %w", path, err)
+	}
+	if store.Profiles == nil {
+		store.Profiles = make(map[string]Config)
+	}
+	return store, nil
+}
+
+// SaveProfiles writes store to path, creating its directory if needed.
+func SaveProfiles(path string, store ProfileStore) error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// PrintProfiles lists the profiles in store, marking the active one with *.
+func PrintProfiles(store ProfileStore, w io.Writer) {
+	if len(store.Profiles) == 0 {
+		fmt.Fprintln(w, "No profiles defined.")
+		return
+	}
+	for _, name := range sortedKeys(store.Profiles) {
+		marker := " "
+		if name == store.Active {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s %s  %s\n", marker, name, store.Profiles[name].CSVFile)
+	}
+}
+
+// inputFiles returns the files the entries of opts are read from.
+func inputFiles(opts Options) []string {
+	if opts.Inputs != nil {
+		return opts.Inputs
+	}
+	return []string{opts.File}
+}
+
+// fileList is a flag.Value that collects the values of a repeated flag.
+type fileList []string
+
+func (l *fileList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *fileList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Options holds the settings parsed from the command-line flags.
+type Options struct {
+	File         string
+	Inputs       []string // files to read when they are not just File
+	Command      string
+	Query        string
+	Pipeline     string
+	Note         string
+	Filter       string
+	FixletID     int
+	SortField    string
+	SortDir      string
+	OutputFormat string
+	Add          AddOptions
+	NewEntry     Entry
+	Data         string
+	Standalone   bool
+	Table        string
+	Upsert       bool
+	Stdin        bool
+	Stdout       bool
+	Backup       bool
+	DryRun       bool
+	IgnoreCase   bool
+	Merge        bool
+	Strict       bool
+	PruneOrphans bool
+	Overwrite    bool
+	OnConflict   string // how dedup resolves duplicates, for ParseResolver
+	InPlace      bool
+	Threshold    int
+	Script       string
+	Watch        bool
+	Server       bool
+	Addr         string
+	Buckets      []Bucket
+	Count        int // entries gen generates or sample picks
+	Iterations   int
+	Seed         int64
+	MaxRetries   int
+	RetryBackoff time.Duration
+	ProgressMB   int
+	Columns      []string
+	PageSize     int
+	CSV          CSVOptions
+}
+
+// WriteJSONLines writes entries to w as JSON Lines: one JSON object per line.
+func WriteJSONLines(entries []Entry, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintEntries writes entries to w in the given output format (text,
+// table, json or jsonl).
+func PrintEntries(entries []Entry, format string, w io.Writer) error {
+	switch format {
+	case "", "text":
+		ListEntries(entries, w)
+	case "table":
+		PrintTable(entries, w)
+	case "json":
+		if entries == nil {
+			entries = []Entry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	case "jsonl":
+		return WriteJSONLines(entries, w)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return nil
+}
+
+// savingCommands are the commands RunCommand saves the entries after.
+var savingCommands = []string{"add", "upsert", "annotate", "delete", "orphan-sites"}
+
+// RunCommand executes a single non-interactive command against the indexed
+// entries. With opts.Stdout set, resulting entries and saved data are written
+// to stdout as CSV instead of being displayed or saved to opts.File.
+func RunCommand(index *EntryIndex, opts Options) error {
+	entries := index.Entries()
+	switch opts.Command {
+	case "list":
+		if len(opts.Columns) > 0 && !opts.Stdout && opts.OutputFormat != "jsonl" {
+			return PrintSelectedColumns(entries, opts.Columns, os.Stdout)
+		}
+		return emitEntries(entries, opts)
+	case "query":
+		if opts.Query == "" {
+			return errors.New("--query is required for the query command")
+		}
+		return emitEntries(QueryEntries(entries, opts.Query), opts)
+	case "sort":
+		if opts.SortDir != "" && opts.SortDir != "asc" && opts.SortDir != "desc" {
+			return fmt.Errorf("invalid sort direction %q", opts.SortDir)
+		}
+		if err := SortEntries(entries, opts.SortField, opts.SortDir == "desc"); err != nil {
+			return err
+		}
+		return emitEntries(entries, opts)
+	case "stats":
+		return PrintStats(Stats(entries), opts.OutputFormat, os.Stdout)
+	case "sample":
+		return emitEntries(RandomSample(entries, opts.Count, opts.Seed), opts)
+	case "where":
+		if opts.Filter == "" {
+			return errors.New("--filter is required for the where command")
+		}
+		pred, err := ParseWhereClause(opts.Filter)
+		if err != nil {
+			return err
+		}
+		return emitEntries(FilterEntries(entries, pred), opts)
+	case "pipeline":
+		if opts.Pipeline == "" {
+			return errors.New("--pipeline is required for the pipeline command")
+		}
+		run, err := ParsePipeline(opts.Pipeline)
+		if err != nil {
+			return err
+		}
+		return emitEntries(run(entries), opts)
+	case "describe":
+		return PrintDescribe(DescribeNumeric(entries), opts.OutputFormat, os.Stdout)
+	case "site-report":
+		return PrintSiteReport(SiteReport(entries), opts.OutputFormat, os.Stdout)
+	case "sum-computers":
+		return PrintComputerSums(SumComputersByCriticality(entries), "Criticality", opts.OutputFormat, os.Stdout)
+	case "sum-computers-site":
+		return PrintComputerSums(SumComputersBySiteID(entries), "SiteID", opts.OutputFormat, os.Stdout)
+	case "export-sql":
+		if opts.Upsert {
+			return ExportSQLUpsert(entries, opts.Table, os.Stdout)
+		}
+		return ExportSQL(entries, opts.Table, os.Stdout)
+	case "validate":
+		return runValidate(entries, opts.Strict)
+	case "schema":
+		PrintSchema(Schema(), os.Stdout)
+		return nil
+	case "aliases":
+		PrintAliases(os.Stdout)
+		return nil
+	case "file-info":
+		return printFileInfo(inputFiles(opts), entries)
+	case "id-gaps":
+		printIDGaps(entries)
+		return nil
+	case "pivot":
+		headers, rows := PivotBySiteID(entries)
+		return writePivotCSV(os.Stdout, headers, rows)
+	case "alert":
+		if n := printAlerts(entries, opts.Threshold); n > 0 {
+			return fmt.Errorf("%d entries exceed the threshold", n)
+		}
+		return nil
+	case "get":
+		e, found := index.Get(opts.FixletID)
+		if !found {
+			return fmt.Errorf("entry with FixletID %d not found", opts.FixletID)
+		}
+		PrintEntryDetails(*e, os.Stdout)
+		return nil
+	case "add", "upsert":
+		e := opts.NewEntry
+		e.FixletID = opts.FixletID
+		if opts.Data != "" {
+			var err error
+			if e, err = parseEntryJSON(opts.Data); err != nil {
+				return err
+			}
+		}
+		if opts.Add.NormalizeNames {
+			e.Name = NormalizeName(e.Name)
+		}
+		var added []Entry
+		var err error
+		if opts.Command == "upsert" {
+			added, _, err = UpsertEntry(slices.Clone(entries), e)
+		} else {
+			added, err = AddEntryFromArgs(slices.Clone(entries), e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
+		}
+		if err != nil {
+			return err
+		}
+		if !opts.Stdout && !opts.DryRun {
+			if err := NewAuditLogger(AuditLogPath(opts.File)).LogChanges(opts.Command, entries, added); err != nil {
+				return err
+			}
+		}
+		return saveEntries(added, opts)
+	case "query-note":
+		if opts.Query == "" {
+			return errors.New("--query is required for the query-note command")
+		}
+		return emitEntries(QueryByNote(entries, opts.Query), opts)
+	case "annotate":
+		annotated, found := AnnotateEntry(slices.Clone(entries), opts.FixletID, opts.Note)
+		if !found {
+			return fmt.Errorf("entry with FixletID %d not found", opts.FixletID)
+		}
+		if !opts.Stdout && !opts.DryRun {
+			if err := NewAuditLogger(AuditLogPath(opts.File)).LogChanges("annotate", entries, annotated); err != nil {
+				return err
+			}
+		}
+		return saveEntries(annotated, opts)
+	case "orphan-sites":
+		sites := FindSingletonSites(entries)
+		printOrphanSites(entries, sites)
+		if !opts.PruneOrphans {
+			return nil
+		}
+		kept, deleted := deleteOrphans(entries, sites)
+		if !deleted {
+			return nil
+		}
+		if !opts.Stdout && !opts.DryRun {
+			if err := NewAuditLogger(AuditLogPath(opts.File)).LogChanges("delete", entries, kept); err != nil {
+				return err
+			}
+		}
+		return saveEntries(kept, opts)
+	case "delete":
+		if !index.Delete(opts.FixletID) {
+			return fmt.Errorf("entry with FixletID %d not found", opts.FixletID)
+		}
+		if !opts.Stdout && !opts.DryRun {
+			if err := NewAuditLogger(AuditLogPath(opts.File)).LogChanges("delete", entries, index.Entries()); err != nil {
+				return err
+			}
+		}
+		return saveEntries(index.Entries(), opts)
+	default:
+		return fmt.Errorf("unknown command %q", opts.Command)
+	}
+}
+
+// runStreamFilter implements --command=filter. The input is streamed rather
+// than loaded so that arbitrarily large files can be filtered; matching
+// entries are written to stdout as CSV, or as JSON Lines with --output=jsonl.
+func runStreamFilter(opts Options) error {
+	if opts.Filter == "" {
+		return errors.New("--filter is required for the filter command")
+	}
+	pred, err := ParseFilter(opts.Filter)
+	if err != nil {
+		return err
+	}
+	// Problematic rows are reported as they are found; the stream goes on.
+	warn := func(rowErr RowError) {
+		fmt.Fprintln(os.Stderr, "Warning:", rowErr)
+	}
+	stream := func(handler func(Entry) error) error { return StreamCSV(opts.File, opts.CSV, handler, warn) }
+	if opts.Inputs != nil {
+		stream = func(handler func(Entry) error) error {
+			for _, filename := range opts.Inputs {
+				err := StreamCSV(filename, opts.CSV, handler, func(rowErr RowError) {
+					rowErr.File = filename
+					warn(rowErr)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	if opts.Stdin {
+		stream = func(handler func(Entry) error) error { return StreamCSVFrom(os.Stdin, opts.CSV, handler, warn) }
+	}
+	if opts.OutputFormat == "jsonl" {
+		enc := json.NewEncoder(os.Stdout)
+		err = stream(func(e Entry) error {
+			if !pred(e) {
+				return nil
+			}
+			return enc.Encode(e)
+		})
+	} else {
+		_, err = streamFilter(stream, opts.CSV, pred, os.Stdout)
+	}
+	return err
+}
+
+// runRepair repairs opts.File with RepairCSV, backing it up first when
+// opts.Backup is set.
+func runRepair(opts Options) error {
+	if opts.Backup {
+		backup, err := BackupCSV(opts.File)
+		if err != nil {
+			return fmt.Errorf("backing up %s: %w", opts.File, err)
+		}
+		info("Original saved as", backup)
+	}
+	n, err := RepairCSV(opts.File, opts.CSV)
+	if err != nil {
+		return err
+	}
+	infof("%d rows repaired in %s.\n", n, opts.File)
+	return nil
+}
+
+// BenchmarkResult holds the timings measured by BenchmarkReadWrite.
+type BenchmarkResult struct {
+	Rows          int
+	Iterations    int
+	ReadP50       time.Duration
+	ReadP99       time.Duration
+	WriteP50      time.Duration
+	WriteP99      time.Duration
+	RowsPerSecond float64 // rows parsed per second while reading
+}
+
+// BenchmarkReadWrite reads the CSV file iterations times and writes its
+// entries back iterations times, to a temporary file next to it so that the
+// same disk is measured, and reports the median and 99th percentile of each.
+// The file itself is not modified.
+func BenchmarkReadWrite(filename string, iterations int, opts CSVOptions) (BenchmarkResult, error) {
+	result := BenchmarkResult{Iterations: iterations}
+	if iterations < 1 {
+		return result, fmt.Errorf("iterations must be at least 1, got %d", iterations)
+	}
+	var entries []Entry
+	reads := make([]time.Duration, iterations)
+	var total time.Duration
+	for i := range reads {
+		start := time.Now()
+		var err error
+		if entries, _, _, err = ReadCSV(filename, opts); err != nil {
+			return result, err
+		}
+		reads[i] = time.Since(start)
+		total += reads[i]
+	}
+	result.Rows = len(entries)
+
+	_, ext := splitExt(filename)
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "bench-*"+ext)
+	if err != nil {
+		return result, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	writes := make([]time.Duration, iterations)
+	for i := range writes {
+		start := time.Now()
+		if err := WriteCSV(tmp.Name(), entries, opts); err != nil {
+			return result, err
+		}
+		writes[i] = time.Since(start)
+	}
+
+	result.ReadP50, result.ReadP99 = percentile(reads, 50), percentile(reads, 99)
+	result.WriteP50, result.WriteP99 = percentile(writes, 50), percentile(writes, 99)
+	if total > 0 {
+		result.RowsPerSecond = float64(result.Rows*iterations) / total.Seconds()
+	}
+	return result, nil
+}
+
+// percentile returns the p-th percentile of durations by the nearest-rank
+// method. durations is sorted in place.
+func percentile(durations []time.Duration, p int) time.Duration {
+	slices.Sort(durations)
+	rank := (p*len(durations) + 99) / 100
+	return durations[max(rank, 1)-1]
+}
+
+// PrintBenchmark writes result to w.
+func PrintBenchmark(result BenchmarkResult, w io.Writer) {
+	fmt.Fprintf(w, "%d rows, %d iterations\n", result.Rows, result.Iterations)
+	fmt.Fprintf(w, "Read:  p50 %v, p99 %v\n", result.ReadP50, result.ReadP99)
+	fmt.Fprintf(w, "Write: p50 %v, p99 %v\n", result.WriteP50, result.WriteP99)
+	fmt.Fprintf(w, "Parsing: %.0f rows/s\n", result.RowsPerSecond)
+}
+
+// runCompact implements --command=compact. With --in-place the CSV file is
+// backed up and then compacted; otherwise the compacted data is written to
+// stdout and the file is left alone.
+func runCompact(opts Options) error {
+	if !opts.InPlace {
+		content, err := readFileContent(opts.File)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %w", ErrFileNotFound, err)
+		}
+		if err != nil {
+			return err
+		}
+		n, err := compactCSV(content, os.Stdout, opts.CSV)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d blank rows removed.\n", n)
+		return nil
+	}
+	backup, err := BackupCSV(opts.File)
+	if err != nil {
+		return fmt.Errorf("backing up %s: %w", opts.File, err)
+	}
+	info("Original saved as", backup)
+	n, err := CompactCSV(opts.File, opts.File, opts.CSV)
+	if err != nil {
+		return err
+	}
+	infof("%d blank rows removed from %s.\n", n, opts.File)
+	return nil
+}
+
+// runAppend implements --command=append: the CSV rows read from stdin are
+// appended to opts.File without loading its existing contents.
+func runAppend(opts Options) error {
+	if !opts.Stdin {
+		return errors.New("--command=append reads the new rows from stdin and requires --stdin")
+	}
+	entries, rowErrors, err := ReadCSVFrom(os.Stdin, opts.CSV)
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range rowErrors {
+		fmt.Fprintln(os.Stderr, "Warning:", rowErr)
+	}
+	if opts.DryRun {
+		fmt.Printf("Dry run: %d entries would be appended to %s.\n", len(entries), opts.File)
+		return WriteCSVTo(os.Stdout, entries, opts.CSV)
+	}
+	if err := AppendCSV(opts.File, entries, opts.CSV); err != nil {
+		return err
+	}
+	return NewAuditLogger(AuditLogPath(opts.File)).LogChanges("append", nil, entries)
+}
+
+// runValidate prints the problems ValidateEntries finds, plus the warnings of
+// SuspiciousEntries when strict is set. It returns an error if any problem
+// was found; warnings alone do not fail.
+func runValidate(entries []Entry, strict bool) error {
+	errs := ValidateEntries(entries)
+	var warnings []ValidationError
+	if strict {
+		warnings = SuspiciousEntries(entries)
+	}
+	PrintValidationErrors(errs, warnings, os.Stdout)
+	if len(errs) > 0 {
+		return fmt.Errorf("validation failed: %d errors", len(errs))
+	}
+	fmt.Printf("%d entries are valid (%d warnings).\n", len(entries), len(warnings))
+	return nil
+}
+
+// entryServer serves the entries of a CSV file over HTTP, saving the file
+// after every change.
+type entryServer struct {
+	mu      sync.Mutex
+	opts    Options
+	audit   *AuditLogger
+	entries []Entry
+}
+
+// maxRequestBody is the largest request body the server reads, in bytes.
+const maxRequestBody = 1 << 20
+
+// StartHTTPServer loads csvFile and serves its entries as JSON at addr until
+// the server fails:
+//
+//	GET    /entries             list every entry
+//	GET    /entries/{fxiletID}  get one entry
+//	POST   /entries             add an entry; a FixletID of 0 assigns the next free one
+//	PUT    /entries/{fxiletID}  replace an entry; empty Notes keep the current ones
+//	DELETE /entries/{fxiletID}  delete an entry
+//
+// Changes are validated like those made in the interactive session and
+// saved to csvFile before the response is sent, honouring opts.Backup and
+// opts.DryRun, and recorded in the audit log. If saving fails the change is
+// undone and the server responds 500. The API has no authentication, so addr
+// should normally be a loopback address.
+func StartHTTPServer(addr string, csvFile string, opts Options) error {
+	opts.File = csvFile
+	entries, _, rowErrors, err := ReadCSV(csvFile, opts.CSV)
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range rowErrors {
+		fmt.Fprintln(os.Stderr, "Warning:", rowErr)
+	}
+	s := &entryServer{opts: opts, audit: NewAuditLogger(AuditLogPath(csvFile)), entries: entries}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/entries", s.collection)
+	mux.HandleFunc("/entries/", s.item)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	infof("Serving %d entries from %s on %s\n", len(entries), csvFile, addr)
+	return server.ListenAndServe()
+}
+
+// collection dispatches requests for /entries by method.
+func (s *entryServer) collection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.list(w, r)
+	case http.MethodPost:
+		s.add(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// item dispatches requests for /entries/{fxiletID} by method.
+func (s *entryServer) item(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/entries/"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid FixletID %q", strings.TrimPrefix(r.URL.Path, "/entries/")))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.get(w, id)
+	case http.MethodPut:
+		s.update(w, r, id)
+	case http.MethodDelete:
+		s.delete(w, id)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *entryServer) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSONResponse(w, http.StatusOK, append([]Entry{}, s.entries...))
+}
+
+func (s *entryServer) get(w http.ResponseWriter, id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, found := GetEntry(s.entries, id)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("%w: FixletID %d", ErrNotFound, id))
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, e)
+}
+
+func (s *entryServer) add(w http.ResponseWriter, r *http.Request) {
+	var e Entry
+	if !decodeJSONBody(w, r, &e) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added, err := newEntry(s.entries, e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
+	if errors.Is(err, ErrDuplicateFxiletID) {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	added.Notes = e.Notes
+	if s.commit(w, "add", append(slices.Clone(s.entries), added)) {
+		writeJSONResponse(w, http.StatusCreated, added)
+	}
+}
+
+func (s *entryServer) update(w http.ResponseWriter, r *http.Request, id int) {
+	var e Entry
+	if !decodeJSONBody(w, r, &e) {
+		return
+	}
+	if e.FixletID == 0 {
+		e.FixletID = id
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, found := GetEntry(s.entries, id)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("%w: FixletID %d", ErrNotFound, id))
+		return
+	}
+	// The replacement is validated as a new entry among all the others.
+	others, _ := DeleteEntry(slices.Clone(s.entries), id)
+	updated, err := newEntry(others, e.SiteID, e.FixletID, e.Name, e.Criticality, e.RelevantComputerCount)
+	if errors.Is(err, ErrDuplicateFxiletID) {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	updated.Notes = cmp.Or(e.Notes, current.Notes)
+	entries, _, _, err := UpdateEntry(slices.Clone(s.entries), id, updated)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.commit(w, "update", entries) {
+		writeJSONResponse(w, http.StatusOK, updated)
+	}
+}
+
+func (s *entryServer) delete(w http.ResponseWriter, id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, found := DeleteEntry(slices.Clone(s.entries), id)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("%w: FixletID %d", ErrNotFound, id))
+		return
+	}
+	if s.commit(w, "delete", entries) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// commit saves entries to the CSV file with saveEntries, records the change
+// in the audit log and makes them the served data. If saving fails it
+// responds 500, keeps the previous data and returns false. A failure to
+// write the audit log is only reported on stderr, since the change is saved.
+func (s *entryServer) commit(w http.ResponseWriter, op string, entries []Entry) bool {
+	if err := saveEntries(entries, s.opts); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("saving %s: %w", s.opts.File, err))
+		return false
+	}
+	if !s.opts.DryRun {
+		if err := s.audit.LogChanges(op, s.entries, entries); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing audit log:", err)
+		}
+	}
+	s.entries = entries
+	return true
+}
+
+// decodeJSONBody decodes the request body into v, responding 400 if it is
+// not a valid JSON object with only Entry fields or is larger than
+// maxRequestBody.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+// writeJSONResponse sends v as a JSON response with the given status code.
+func writeJSONResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError sends err as a JSON object of the form {"error": "..."}.
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSONResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+// emitEntries displays the result of a one-shot command.
+func emitEntries(entries []Entry, opts Options) error {
+	if opts.Stdout {
+		return WriteCSVTo(os.Stdout, entries, opts.CSV)
+	}
+	return PrintEntries(entries, opts.OutputFormat, os.Stdout)
+}
+
+// saveEntries persists the dataset, backing up the current file first when
+// opts.Backup is set.
+func saveEntries(entries []Entry, opts Options) error {
+	if opts.Stdout {
+		return WriteCSVTo(os.Stdout, entries, opts.CSV)
+	}
+	if opts.DryRun {
+		return previewSave(entries, opts)
+	}
+	if opts.Backup {
+		if _, err := os.Stat(opts.File); err == nil {
+			if _, err := BackupCSV(opts.File); err != nil {
+				return fmt.Errorf("backing up %s: %w", opts.File, err)
+			}
+		}
+	}
+	start := time.Now()
+	if err := WriteCSVWithRetry(opts.File, entries, opts.CSV, opts.MaxRetries, opts.RetryBackoff); err != nil {
+		return err
+	}
+	if VerboseMode {
+		if stat, err := os.Stat(opts.File); err == nil {
+			debugf("wrote %d rows, %d bytes to %s in %v", len(entries), stat.Size(), opts.File, time.Since(start))
+		}
+	}
+	return nil
+}
+
+// previewSave prints a unified diff of the current content of opts.File
+// against the content entries would be saved as, without writing anything.
+func previewSave(entries []Entry, opts Options) error {
+	current, err := readFileContent(opts.File)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var proposed bytes.Buffer
+	if err := writeLastModified(&proposed); err != nil {
+		return err
+	}
+	if err := WriteCSVTo(&proposed, entries, opts.CSV); err != nil {
+		return err
+	}
+	// Every save updates the last_modified comment, which on its own is not
+	// a change worth showing.
+	if stripLastModified(current) == stripLastModified(proposed.String()) {
+		fmt.Println("Dry run: no changes to", opts.File)
+		return nil
+	}
+	diff := UnifiedDiff(splitLines(current), splitLines(proposed.String()), opts.File, opts.File+" (proposed)", 3)
+	fmt.Print(diff)
+	fmt.Println("Dry run:", opts.File, "was not written.")
+	return nil
+}
+
+// readFileContent returns the content of filename, decompressing it when
+// the name ends in .gz.
+func readFileContent(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil || !isGzipFile(filename) {
+		return string(data), err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid gzip file: %w", filename, err)
+	}
+	defer gz.Close()
+	data, err = io.ReadAll(gz)
+	return string(data), err
+}
+
+// splitLines splits s into lines without their trailing newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffOp is one line of an edit script: ' ' for a kept line, '-' for a
+// removed line and '+' for an added line.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns an edit script turning a into b. The common prefix and
+// suffix are trimmed before computing the longest common subsequence of the
+// remaining lines.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:].
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, diffOp{' ', ma[i]})
+			i++
+			j++
+		case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', ma[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', mb[j]})
+			j++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// UnifiedDiff returns the differences between lines a and b in unified diff
+// format with the given number of context lines, or "" when they are equal.
+func UnifiedDiff(a, b []string, fromName, toName string, context int) string {
+	ops := diffLines(a, b)
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// Extend the hunk while the next change is close enough for the
+		// context lines to overlap.
+		end := start
+		for k := start; k < len(ops) && k <= end+2*context; k++ {
+			if ops[k].kind != ' ' {
+				end = k
+			}
+		}
+		from := max(start-context, 0)
+		to := min(end+context+1, len(ops))
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		aLine, bLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		if aLen == 0 {
+			aLine--
+		}
+		if bLen == 0 {
+			bLine--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aLine, aLen, bLine, bLen)
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// ResolveCsvFilename returns the value of the environment variable envKey,
+// or defaultVal when it is unset or empty.
+func ResolveCsvFilename(envKey, defaultVal string) string {
+	if v := os.Getenv(envKey); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+// showProgress reports whether loading filename deserves a progress bar: it
+// is larger than thresholdMB megabytes and stderr is a terminal.
+func showProgress(filename string, thresholdMB int) bool {
+	info, err := os.Stat(filename)
+	if err != nil || info.Size() <= int64(thresholdMB)<<20 {
+		return false
+	}
+	stderr, err := os.Stderr.Stat()
+	return err == nil && stderr.Mode()&os.ModeCharDevice != 0
+}
+
+// usage prints the command-line help, including the environment variables.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(out, "Without --command the program starts an interactive session.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Environment:")
+	fmt.Fprintln(out, "  APP_CSV_FILE  CSV file to use when --file is not given (default \"fixlets.csv\")")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Settings are also read from ~/.fixlets.toml, or the file named by --config.")
+	fmt.Fprintln(out, "The active profile in ~/.fixlets/profiles.json overrides the config file.")
+	fmt.Fprintln(out, "Command-line flags take precedence over both.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Flags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	defaultFile := ResolveCsvFilename("APP_CSV_FILE", "fixlets.csv")
+	var opts Options
+	flag.Usage = usage
+	configPath := flag.String("config", "", "config file to load (default ~/.fixlets.toml)")
+	var files fileList
+	flag.Var(&files, "file", fmt.Sprintf("CSV file to operate on (overrides APP_CSV_FILE and csv_file; default %q); repeat it to read several files and save to the first", defaultFile))
+	out := flag.String("out", "", "save changes to this file instead of the one named by --file")
+	flag.StringVar(&opts.Command, "command", "", "run a single command and exit (list, query, query-note, filter, where, sort, sample, pipeline, stats, describe, site-report, sum-computers, sum-computers-site, orphan-sites, pivot, export-sql, validate, schema, aliases, file-info, id-gaps, alert, get, add, upsert, annotate, append, delete, gen, repair, compact, check-encoding, bench)")
+	flag.BoolVar(&opts.Server, "server", false, "serve the entries of the CSV file as a JSON REST API instead of starting a session")
+	flag.StringVar(&opts.Addr, "addr", "127.0.0.1:8080", "address the --server listens on; the API has no authentication, so use a non-loopback address such as :8080 only on trusted networks")
+	flag.BoolVar(&opts.Watch, "watch", false, "reload the CSV file in the interactive session whenever it changes on disk")
+	flag.StringVar(&opts.Script, "script", "", "run the interactive commands in this file, one per line, and exit")
+	flag.StringVar(&opts.Pipeline, "pipeline", "", "steps for --command=pipeline, e.g. \"filter Criticality=High | sort Computers,desc | limit 10\"")
+	flag.StringVar(&opts.Filter, "filter", "", "filter expression for --command=filter, e.g. \"Criticality=Critical AND Computers>100\"; the file is streamed and matches are written to stdout as CSV. With --command=where, a condition such as \"name like '%patch%' and criticality = 'High'\"")
+	flag.StringVar(&opts.Query, "query", "", "name or criticality to search for with --command=query, or text to find in notes with --command=query-note")
+	flag.IntVar(&opts.FixletID, "fxilet-id", 0, "FixletID to act on with --command=get, delete or annotate, or to assign with --command=add (0 assigns the next free one)")
+	flag.StringVar(&opts.Note, "note", "", "note --command=annotate sets on the entry; empty removes it")
+	flag.IntVar(&opts.NewEntry.SiteID, "site-id", 0, "SiteID of the entry added with --command=add")
+	flag.StringVar(&opts.NewEntry.Name, "name", "", "Name of the entry added with --command=add")
+	flag.StringVar(&opts.NewEntry.Criticality, "criticality", "", "Criticality of the entry added with --command=add")
+	flag.IntVar(&opts.NewEntry.RelevantComputerCount, "computers", 0, "RelevantComputerCount of the entry added with --command=add")
+	flag.StringVar(&opts.Data, "data", "", "the entry added with --command=add as a JSON object, e.g. '{\"SiteID\":1,\"Name\":\"Foo\",\"Criticality\":\"High\"}'; replaces --site-id, --name and the other entry flags")
+	flag.StringVar(&opts.SortField, "sort-field", "RelevantComputerCount", "field to sort by with --command=sort")
+	flag.StringVar(&Locale, "locale", "", "sort names for this locale (e.g. en-US), ignoring case and accents, instead of by bytes")
+	flag.StringVar(&opts.SortDir, "sort-dir", "asc", "sort direction with --command=sort (asc or desc)")
+	flag.StringVar(&opts.OutputFormat, "output-format", "text", "output format for listed entries and reports (text, table, json or jsonl; site-report also accepts csv); tsv instead reads and writes the file as tab-separated values")
+	// The shorthands share the value of --output-format rather than binding
+	// the field again with defaults of their own.
+	outputFormat := flag.Lookup("output-format").Value
+	flag.Var(outputFormat, "format", "shorthand for -output-format")
+	flag.Var(outputFormat, "output", "shorthand for -output-format")
+	flag.BoolVar(&opts.Add.ManualID, "manual-id", false, "prompt for the FixletID when adding instead of assigning the next free one")
+	flag.StringVar(&opts.Table, "table", "fixlets", "table name used by export-sql")
+	flag.BoolVar(&opts.Upsert, "upsert", false, "make export-sql update rows whose FixletID already exists (PostgreSQL ON CONFLICT)")
+	flag.BoolVar(&opts.Standalone, "standalone", false, "wrap export-html output in a complete HTML page")
+	flag.BoolVar(&opts.Stdin, "stdin", false, "read CSV data from stdin instead of --file (requires --command)")
+	flag.BoolVar(&opts.Stdout, "stdout", false, "write resulting CSV data to stdout instead of --file (requires --command)")
+	flag.BoolVar(&QuietMode, "quiet", false, "suppress messages that only confirm success; results and errors are still shown")
+	flag.BoolVar(&VerboseMode, "verbose", false, "print debug messages on stderr: file used, rows read, bytes written and timing")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "print a unified diff of what would be saved instead of writing the CSV file")
+	flag.DurationVar(&LockTimeout, "lock-timeout", LockTimeout, "how long to wait for another instance to finish writing the CSV file (overrides lock_timeout)")
+	flag.IntVar(&opts.ProgressMB, "progress-threshold-mb", 10, "show a progress bar on stderr while loading CSV files larger than this many megabytes (overrides progress_threshold_mb)")
+	flag.IntVar(&opts.MaxRetries, "max-retries", 0, "how many times to retry reading or saving the CSV file after a transient error, such as on a network share")
+	flag.DurationVar(&opts.RetryBackoff, "retry-backoff", 100*time.Millisecond, "wait before the first retry; it doubles with every further retry")
+	flag.BoolVar(&opts.Backup, "backup", false, "make a timestamped backup of the CSV file before every save")
+	flag.BoolVar(&opts.IgnoreCase, "ignore-case", false, "make query-regex patterns case-insensitive")
+	flag.IntVar(&opts.Threshold, "threshold", 0, "RelevantComputerCount above which --command=alert reports an entry")
+	flag.BoolVar(&opts.InPlace, "in-place", false, "make --command=compact back up and overwrite the CSV file instead of writing to stdout")
+	flag.BoolVar(&opts.Overwrite, "overwrite", false, "let split-criticality and gen replace files that already exist")
+	flag.StringVar(&opts.OnConflict, "on-conflict", "first", "which of the entries sharing a FixletID dedup keeps: first or higher-computers")
+	flag.IntVar(&opts.Count, "count", 100, "number of entries gen generates (with --command=gen the output is --out), or sample picks")
+	flag.IntVar(&opts.Iterations, "iterations", 10, "how many times bench reads and writes the CSV file")
+	flag.Int64Var(&opts.Seed, "seed", 1, "random seed for gen and sample; the same seed always gives the same entries")
+	flag.BoolVar(&opts.Strict, "strict", false, "make validate also warn about suspicious values")
+	flag.BoolVar(&opts.PruneOrphans, "delete-orphans", false, "make orphan-sites delete the entries of the sites it lists, after confirmation")
+	flag.BoolVar(&opts.Merge, "merge", false, "allow rename-site to move entries onto a SiteID that already exists")
+	buckets := flag.String("buckets", "", "ranges that count-by groups numeric fields into, e.g. 0-100,101-500,501+")
+	columnOrder := flag.String("column-order", "", "comma-separated column order used when writing CSV data (e.g. FixletID,Name,Criticality,SiteID,RelevantComputerCount)")
+	columns := flag.String("columns", "", "comma-separated columns to show in list, export-json and export-md (e.g. SiteID,Name,Criticality)")
+	flag.BoolVar(&opts.CSV.SkipHeader, "no-header", false, "leave out the header when writing CSV data to stdout (requires --stdout or --command=filter)")
+	normalizeNames := flag.Bool("normalize-names", false, "normalize the casing of names when reading the CSV file and adding entries")
+	delimiter := flag.String("delimiter", ",", "CSV field delimiter (a single character, or \"tab\")")
+	flag.Parse()
+	opts.File = defaultFile
+	if len(files) > 0 {
+		opts.File = files[0]
+	}
+	opts.CSV.NormalizeNames = *normalizeNames
+	opts.Add.NormalizeNames = *normalizeNames
+	opts.PageSize = 25
+
+	// Settings from the config file apply unless overridden on the command line.
+	path := *configPath
+	if path == "" {
+		path = DefaultConfigPath()
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil && (*configPath != "" || !errors.Is(err, os.ErrNotExist)) {
+		fmt.Fprintln(os.Stderr, "Error loading config:", err)
+		os.Exit(2)
+	}
+	if opts.CSV.Delimiter, err = ParseDelimiter(*delimiter); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(2)
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	pinned := func(name string) bool {
+		return set[name] || name == "file" && os.Getenv("APP_CSV_FILE") != ""
+	}
+	if opts.OutputFormat == "tsv" {
+		opts.OutputFormat = "text"
+		opts.CSV.Delimiter = '\t'
+		set["delimiter"] = true
+	}
+	if err := applyConfig(&opts, cfg, pinned); err != nil {
+		fmt.Fprintln(os.Stderr, "Error in config:", err)
+		os.Exit(2)
+	}
+	// The active profile overrides the config file in the same way.
+	profiles, err := LoadProfiles(DefaultProfilesPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading profiles:", err)
+		os.Exit(2)
+	}
+	if profile, ok := profiles.Profiles[profiles.Active]; ok {
+		if err := applyConfig(&opts, profile, pinned); err != nil {
+			fmt.Fprintf(os.Stderr, "Error in profile %q: %v\n", profiles.Active, err)
+			os.Exit(2)
+		}
+	}
+	// --file may be repeated to read several files one after another.
+	// Changes are saved to the first of them, or to --out.
+	if len(files) > 1 {
+		opts.Inputs = files
+	}
+	if *out != "" {
+		if opts.Inputs == nil {
+			opts.Inputs = []string{opts.File}
+		}
+		opts.File = *out
+	}
+	// Without an explicit delimiter, tab-separated files are recognized by
+	// their header line.
+	if !set["delimiter"] && !opts.Stdin && opts.CSV.Delimiter == ',' && looksLikeTSV(cmp.Or(slices.Concat(opts.Inputs, []string{opts.File})...)) {
+		opts.CSV.Delimiter = '\t'
+	}
+	if *columns != "" {
+		if opts.Columns, err = ResolveColumns(strings.Split(*columns, ",")); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+	}
+	if *buckets != "" {
+		if opts.Buckets, err = ParseBuckets(*buckets); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+	}
+	if _, err := ParseResolver(opts.OnConflict); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: --on-conflict:", err)
+		os.Exit(2)
+	}
+	if *columnOrder != "" {
+		if opts.CSV.Columns, err = ResolveColumns(strings.Split(*columnOrder, ",")); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+		// A partial column list would drop data from the saved file.
+		if !opts.Stdout && !isFieldPermutation(opts.CSV.Columns) {
+			fmt.Fprintln(os.Stderr, "Error: --column-order must list every column unless --stdout is set")
+			os.Exit(2)
+		}
+	}
+	// A saved file without a header could not be read back.
+	if opts.CSV.SkipHeader && !opts.Stdout && opts.Command != "filter" {
+		fmt.Fprintln(os.Stderr, "Error: --no-header requires --stdout or --command=filter")
+		os.Exit(2)
+	}
+	if opts.Script != "" && opts.Command != "" {
+		fmt.Fprintln(os.Stderr, "Error: --script and --command cannot be combined")
+		os.Exit(2)
+	}
+	if (opts.Stdin || opts.Stdout) && opts.Command == "" {
+		fmt.Fprintln(os.Stderr, "Error: --stdin and --stdout require --command")
+		os.Exit(2)
+	}
+	// These modes work on a single file without loading it first.
+	if opts.Inputs != nil && opts.Command != "gen" && (opts.Server || slices.Contains([]string{"repair", "check-encoding", "bench", "compact", "append"}, opts.Command)) {
+		fmt.Fprintln(os.Stderr, "Error: this mode works on a single file; --file cannot be repeated and --out cannot be used")
+		os.Exit(2)
+	}
+	if opts.Server {
+		if opts.Command != "" || opts.Script != "" {
+			fmt.Fprintln(os.Stderr, "Error: --server cannot be combined with --command or --script")
+			os.Exit(2)
+		}
+		if err := StartHTTPServer(opts.Addr, opts.File, opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.Command == "filter" {
+		if err := runStreamFilter(opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// gen writes a new file, so it takes an explicit --out rather than
+	// defaulting to the file the other commands work on.
+	if opts.Command == "gen" {
+		if *out == "" {
+			fmt.Fprintln(os.Stderr, "Error: --command=gen requires --out to name the file to write")
+			os.Exit(2)
+		}
+		if err := writeFixtures(*out, opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		infof("%d entries written to %s.\n", opts.Count, *out)
+		return
+	}
+	if opts.Command == "repair" {
+		if err := runRepair(opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.Command == "check-encoding" {
+		enc, err := DetectEncoding(opts.File)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %s\n", opts.File, enc)
+		return
+	}
+	if opts.Command == "bench" {
+		result, err := BenchmarkReadWrite(opts.File, opts.Iterations, opts.CSV)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		PrintBenchmark(result, os.Stdout)
+		return
+	}
+	if opts.Command == "compact" {
+		if err := runCompact(opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.Command == "append" {
+		if err := runAppend(opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// Commands that change the entries keep the file locked from loading
+	// it until they have saved it, so that no other instance writes it in
+	// between.
+	exit := os.Exit
+	if !opts.Stdin && !opts.Stdout && !opts.DryRun && slices.Contains(savingCommands, opts.Command) {
+		lock, err := LockFile(opts.File)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		defer lock.Unlock()
+		exit = func(code int) {
+			lock.Unlock()
+			os.Exit(code)
+		}
+	}
+	// Read the existing CSV data
+	var entries []Entry
+	var rowErrors []RowError
+	source := opts.File
+	switch {
+	case opts.Stdin:
+		source = "stdin"
+	case opts.Inputs != nil:
+		source = strings.Join(opts.Inputs, ", ")
+	default:
+		if abs, err := filepath.Abs(opts.File); err == nil {
+			debugf("using CSV file %s", abs)
+		}
+	}
+	start := time.Now()
+	if opts.Stdin {
+		entries, rowErrors, err = ReadCSVFrom(os.Stdin, opts.CSV)
+	} else if opts.Inputs != nil {
+		entries, rowErrors, err = ReadCSVMulti(opts.Inputs, opts.CSV)
+	} else if showProgress(opts.File, opts.ProgressMB) {
+		err = retry(opts.MaxRetries, opts.RetryBackoff, func() error {
+			var err error
+			entries, rowErrors, err = ReadCSVProgress(opts.File, opts.CSV, progressBar(os.Stderr, "Loading "+opts.File))
+			return err
+		})
+	} else {
+		entries, rowErrors, err = ReadCSVWithRetry(opts.File, opts.CSV, opts.MaxRetries, opts.RetryBackoff)
+	}
+	if errors.Is(err, ErrFileNotFound) {
+		missing := opts.File
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			missing = pathErr.Path
+		}
+		fmt.Fprintf(os.Stderr, "Error: CSV file %q does not exist. Use --file or APP_CSV_FILE to choose another file.\n", missing)
+		exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading CSV file:", err)
+		exit(1)
+	}
+	for _, rowErr := range rowErrors {
+		fmt.Fprintln(os.Stderr, "Warning:", rowErr)
+	}
+	debugf("read %d rows from %s in %v (%d row errors)", len(entries), source, time.Since(start), len(rowErrors))
+	if opts.Script != "" {
+		_, results, err := RunScript(opts.Script, entries, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error running script:", err)
+			os.Exit(1)
+		}
+		failed := 0
+		for _, r := range results {
+			if r.Err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: %s: %v\n", opts.Script, r.Line, r.Command, r.Err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d script commands failed.\n", failed, len(results))
+			os.Exit(1)
+		}
+		return
+	}
+	if opts.Command != "" {
+		if err := RunCommand(NewEntryIndex(entries), opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			exit(1)
+		}
+		return
+	}
+	RunInteractive(opts, entries)
+}
+
+// RunInteractive runs the interactive command loop until the user exits.
+func RunInteractive(opts Options, entries []Entry) {
+	runSession(opts, entries, nil)
+}
+
+// ScriptResult is the outcome of one command of a script.
+type ScriptResult struct {
+	Line    int    // line of the script holding the command
+	Command string // the command as written
+	Changed bool   // the command changed the entries and saved them
+	Err     error  // the first problem the command reported, if any
+}
+
+// RunScript executes the commands in the script file in order, using the
+// same syntax as the interactive session; a command that prompts for input
+// reads it from the following lines. Blank lines and lines starting with #
+// are skipped. Changes are saved to opts.File by a save command and, if any
+// are left, when the script ends. It returns the final entries and one result
+// per command.
+func RunScript(filename string, entries []Entry, opts Options) ([]Entry, []ScriptResult, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return entries, nil, err
+	}
+	src := bytes.NewReader(content)
+	saved := stdin
+	stdin = bufio.NewReader(src)
+	defer func() { stdin = saved }()
+	lineAt := func() int {
+		offset := len(content) - src.Len() - stdin.Buffered()
+		return bytes.Count(content[:offset], []byte("\n"))
+	}
+	entries, results := runSession(opts, entries, lineAt)
+	return entries, results, nil
+}
+
+// runSession reads commands from stdin until "exit" or the end of input and
+// returns the resulting entries. When lineAt is nil the session is
+// interactive and shows the menu before every command; otherwise commands
+// come from a script, lineAt reports the number of lines consumed so far and
+// the outcome of every command is returned.
+func runSession(opts Options, entries []Entry, lineAt func() int) ([]Entry, []ScriptResult) {
+	var undo UndoStack
+	var results []ScriptResult
+	var changed bool
+	// dirty is set while the entries differ from what was last written.
+	var dirty bool
+	var failure error
+	audit := NewAuditLogger(AuditLogPath(opts.File))
+	// pending holds the audit events of the unsaved changes; they are
+	// logged when the entries are written and dropped with the changes.
+	var pending []AuditEvent
+	// index answers get, add and delete by FixletID. Those commands keep it
+	// up to date; other commands replace or reorder the entries, and lookup
+	// rebuilds it from them the next time it is needed.
+	index := NewEntryIndex(entries)
+	lookup := func() *EntryIndex {
+		if !index.holds(entries) {
+			index = NewEntryIndex(entries)
+		}
+		return index
+	}
+	// With --watch, changes made to the file by others arrive on reloads and
+	// are applied before the next command runs.
+	reloads := make(chan []Entry, 1)
+	if opts.Watch && lineAt == nil && !opts.Stdout {
+		go func() {
+			err := WatchCSVMulti(inputFiles(opts), opts.CSV, func(changed []Entry) {
+				select {
+				case <-reloads: // Replace a reload that has not been applied yet.
+				default:
+				}
+				reloads <- changed
+			})
+			fmt.Println("\nStopped watching", strings.Join(inputFiles(opts), ", ")+":", err)
+		}()
+	}
+	// fail reports a problem with the current command.
+	fail := func(a ...any) {
+		fmt.Println(a...)
+		if failure == nil {
+			failure = errors.New(strings.TrimSpace(fmt.Sprintln(a...)))
+		}
+	}
+	// Interactive sessions share their search terms through the history
+	// file; scripts only keep them for the session.
+	history := new(QueryHistory)
+	historyPath := DefaultQueryHistoryPath()
+	if lineAt == nil {
+		var err error
+		if history, err = LoadQueryHistory(historyPath); err != nil {
+			fmt.Println("Warning: could not read query history:", err)
+		}
+	}
+	// recall expands a "!N" history reference in term and records the
+	// result. It reports false if the reference does not exist.
+	recall := func(term *string) bool {
+		expanded, err := history.Recall(*term)
+		if err != nil {
+			fail(err)
+			return false
+		}
+		if expanded != *term {
+			info(expanded)
+		}
+		*term = expanded
+		history.Add(expanded)
+		if lineAt == nil && expanded != "" {
+			if err := AppendQueryHistory(historyPath, expanded); err != nil {
+				fmt.Println("Warning: could not save query history:", err)
+			}
+		}
+		return true
+	}
+	// modified marks the entries as changed; they are written to the file
+	// by the save command or when the session ends.
+	modified := func() {
+		changed = true
+		dirty = true
+	}
+	// persist writes the entries to opts.File and logs the pending audit
+	// events.
+	persist := func() {
+		if err := saveEntries(entries, opts); err != nil {
+			fail("Error saving CSV file:", err)
+			return
+		}
+		// Dry runs and --stdout leave the file as it was.
+		dirty = opts.DryRun || opts.Stdout
+		if dirty {
+			return
+		}
+		for _, ev := range pending {
+			if err := audit.Log(ev); err != nil {
+				fail("Error writing audit log:", err)
+				break
+			}
+		}
+		pending = nil
+	}
+	// finish deals with unsaved changes at the end of the session: a script
+	// saves them, an interactive session asks first.
+	finish := func() {
+		if !dirty {
+			return
+		}
+		if lineAt == nil && !confirm(fmt.Sprintf("Save changes to %s before exiting?", opts.File)) {
+			fmt.Println("Unsaved changes discarded.")
+			return
+		}
+		failure = nil
+		persist()
+		if lineAt != nil && failure != nil {
+			results = append(results, ScriptResult{lineAt(), "save", false, failure})
+		}
+	}
+	// reload replaces the entries with the content of the input files,
+	// keeping the previous entries on the undo stack.
+	reload := func() {
+		loaded, rowErrors, err := ReadCSVMulti(inputFiles(opts), opts.CSV)
+		if err != nil {
+			fail("Error reading CSV file:", err)
+			return
+		}
+		for _, rowErr := range rowErrors {
+			fmt.Println("Warning:", rowErr)
+		}
+		undo.Push(entries)
+		entries = loaded
+		dirty = false
+		pending = nil
+		infof("Reloaded %d entries from %s.\n", len(entries), strings.Join(inputFiles(opts), ", "))
+	}
+	// record queues the audit events of a change until it is saved.
+	record := func(op string, before []Entry) {
+		if opts.DryRun || opts.Stdout {
+			return
+		}
+		now := time.Now().UTC()
+		for _, ev := range changeEvents(op, before, entries) {
+			ev.Timestamp = now
+			pending = append(pending, ev)
+		}
+	}
+	// commit finishes a mutating operation: it makes the change undoable,
+	// records it for the audit log and marks the entries as unsaved.
+	commit := func(op string, before []Entry) {
+		undo.Push(before)
+		record(op, before)
+		modified()
+	}
+	// show displays entries found by a command, one JSON object per line
+	// with --output=jsonl.
+	show := func(list []Entry) {
+		if opts.OutputFormat != "jsonl" {
+			ListEntries(list, os.Stdout)
+			return
+		}
+		if err := WriteJSONLines(list, os.Stdout); err != nil {
+			fail("Error writing entries:", err)
+		}
+	}
+	// Command-line interactions
+	for {
+		if lineAt == nil {
+			prompt := "\n"
+			if dirty {
+				prompt += "[unsaved] "
+			}
+			fmt.Println(prompt + "Choose an operation: list, table, get, query, query-regex, query-site, query-note, filter, where, pipeline, count, count-by, stats, describe, site-report, sum-computers, sum-computers-site, orphan-sites, validate, schema, aliases, file-info, alert, freq, crosstab, pivot, first, last, top, bottom, sample, add, upsert, update, annotate, copy, delete, delete-filter, sort, dedup, id-gaps, compact-ids, rename-site, replace-name, trim, repair, compact, check-encoding, convert-encoding, gen, bench, export-json, export-md, export-html, export-sql, import-json, import-csv, merge, undo, restore, audit, diff, group-site, group-criticality, split-site, split-criticality, case-variants, profile, history, config show, save, save-as, reload, exit")
+		}
+		line, err := readLine()
+		if err != nil {
+			finish()
+			undo.Clear()
+			if lineAt == nil {
+				info("Exiting program.")
+			}
+			return entries, results
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		command, args := fields[0], fields[1:]
+		select {
+		case reloaded := <-reloads:
+			// Our own saves are seen as changes too; only differing data
+			// was written by someone else.
+			if dirty && !slices.Equal(reloaded, entries) {
+				fmt.Printf("%s changed on disk; use reload to load it, discarding your unsaved changes.\n", strings.Join(inputFiles(opts), ", "))
+			} else if !slices.Equal(reloaded, entries) {
+				entries = reloaded
+				dirty = false
+				undo.Clear()
+				fmt.Printf("%s changed on disk; reloaded %d entries.\n", strings.Join(inputFiles(opts), ", "), len(entries))
+			}
+		default:
+		}
+		changed, failure = false, nil
+		var lineNo int
+		if lineAt != nil {
+			lineNo = lineAt()
+		}
+
+		switch command {
+		case "list":
+			page, size := 1, opts.PageSize
+			if len(args) > 0 {
+				if page, err = strconv.Atoi(args[0]); err != nil {
+					fail("Invalid page number:", args[0])
+					break
+				}
+			}
+			if len(args) > 1 {
+				if size, err = strconv.Atoi(args[1]); err != nil {
+					fail("Invalid page size:", args[1])
+					break
+				}
+			}
+			if len(opts.Columns) > 0 && opts.OutputFormat != "jsonl" {
+				listPage(entries, page, size, os.Stdout, func(page []Entry) { PrintSelectedColumns(page, opts.Columns, os.Stdout) })
+			} else {
+				listPage(entries, page, size, os.Stdout, show)
+			}
+		case "table":
+			PrintTable(entries, os.Stdout)
+		case "query":
+			fmt.Println("Enter name or criticality to query (or !N to repeat history item N):")
+			query, _ := readLine()
+			if !recall(&query) {
+				break
+			}
+			if opts.OutputFormat == "jsonl" {
+				show(QueryEntries(entries, query))
+			} else {
+				QueryEntry(entries, query, os.Stdout)
+			}
+		case "query-note":
+			fmt.Println("Enter text to search for in notes:")
+			query, _ := readLine()
+			matches := QueryByNote(entries, query)
+			if len(matches) == 0 {
+				fmt.Println("No entries found.")
+				break
+			}
+			show(matches)
+		case "annotate":
+			var fixletID int
+			fmt.Println("Enter FixletID to annotate:")
+			fmt.Fscanln(stdin, &fixletID)
+			if _, found := GetEntry(entries, fixletID); !found {
+				fail("Entry not found.")
+				break
+			}
+			fmt.Println("Enter note (leave empty to remove it):")
+			note, _ := readLine()
+			before := slices.Clone(entries)
+			entries, _ = AnnotateEntry(entries, fixletID, note)
+			commit("annotate", before)
+			info("Entry annotated.")
+		case "query-regex":
+			fmt.Println("Enter regular expression to match against name or criticality:")
+			pattern, _ := readLine()
+			if opts.IgnoreCase {
+				pattern = "(?i)" + pattern
+			}
+			matches, err := QueryEntryRegex(entries, pattern)
+			if err != nil {
+				fail("Error querying entries:", err)
+				break
+			}
+			if len(matches) == 0 {
+				fmt.Println("No entries found.")
+				break
+			}
+			ListEntries(matches, os.Stdout)
+		case "query-site":
+			var siteID int
+			fmt.Println("Enter SiteID to query:")
+			fmt.Fscanln(stdin, &siteID)
+			matches, err := QueryBySiteID(entries, siteID)
+			if err != nil {
+				fail("Error querying site:", err)
+				break
+			}
+			if len(matches) == 0 {
+				fmt.Println("No entries found.")
+				break
+			}
+			ListEntries(matches, os.Stdout)
+		case "filter":
+			expr := strings.Join(args, " ")
+			if expr == "" {
+				fmt.Println("Enter filter expression (e.g. Computers>50, or Criticality=High AND Computers>100):")
+				expr, _ = readLine()
+			}
+			if !recall(&expr) {
+				break
+			}
+			pred, err := ParseFilter(expr)
+			if err != nil {
+				fail("Error parsing filter:", err)
+				break
+			}
+			show(FilterEntries(entries, pred))
+		case "where":
+			// Quoted values keep their spacing, so the clause is the raw
+			// text after the command word rather than the joined args.
+			clause := strings.TrimSpace(strings.TrimSpace(line)[len(command):])
+			if clause == "" {
+				fmt.Printf("Enter condition (e.g. name like '%%patch%%' and criticality = 'High'):\n")
+				clause, _ = readLine()
+			}
+			if !recall(&clause) {
+				break
+			}
+			pred, err := ParseWhereClause(clause)
+			if err != nil {
+				fail("Error parsing condition:", err)
+				break
+			}
+			show(FilterEntries(entries, pred))
+		case "history":
+			PrintQueryHistory(history, os.Stdout)
+		case "pipeline":
+			spec := strings.Join(args, " ")
+			if spec == "" {
+				fmt.Println("Enter pipeline (e.g. filter Criticality=High | sort Computers,desc | limit 10):")
+				spec, _ = readLine()
+			}
+			run, err := ParsePipeline(spec)
+			if err != nil {
+				fail("Error parsing pipeline:", err)
+				break
+			}
+			show(run(entries))
+		case "sort":
+			if len(args) > 0 {
+				keys := make([]SortKey, len(args))
+				for i, spec := range args {
+					if keys[i], err = ParseSortKey(spec); err != nil {
+						break
+					}
+				}
+				if err == nil {
+					err = SortEntriesMulti(entries, keys)
+				}
+			} else {
+				var field, direction string
+				fmt.Println("Enter field to sort by (SiteID, FixletID, Name, Criticality, RelevantComputerCount):")
+				fmt.Fscanln(stdin, &field)
+				fmt.Println("Enter direction (asc/desc):")
+				fmt.Fscanln(stdin, &direction)
+				err = SortEntries(entries, field, strings.EqualFold(direction, "desc"))
+			}
+			// Sorting moves entries in place, out of their indexed positions.
+			index = NewEntryIndex(entries)
+			if err != nil {
+				fail("Error sorting entries:", err)
+				break
+			}
+			show(entries)
+		case "top", "bottom":
+			fmt.Println("Enter number of entries to show (default 10):")
+			answer, _ := readLine()
+			n := 10
+			if answer != "" {
+				if n, err = strconv.Atoi(answer); err != nil || n < 1 {
+					fail("Invalid number:", answer)
+					break
+				}
+			}
+			if command == "top" {
+				show(TopN(entries, n))
+			} else {
+				show(BottomN(entries, n))
+			}
+		case "first", "last":
+			n := 1
+			if len(args) > 0 {
+				if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+					fail("Invalid number:", args[0])
+					break
+				}
+			}
+			if n == 1 {
+				get := FirstEntry
+				if command == "last" {
+					get = LastEntry
+				}
+				if e, ok := get(entries); ok {
+					show([]Entry{*e})
+				} else {
+					show(nil)
+				}
+				break
+			}
+			n = min(n, len(entries))
+			if command == "first" {
+				show(entries[:n])
+			} else {
+				show(entries[len(entries)-n:])
+			}
+		case "sample":
+			n, seed := opts.Count, opts.Seed
+			if len(args) > 0 {
+				if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+					fail("Invalid number:", args[0])
+					break
+				}
+			}
+			if len(args) > 1 {
+				if seed, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+					fail("Invalid seed:", args[1])
+					break
+				}
+			}
+			show(RandomSample(entries, n, seed))
+		case "count":
+			if len(args) == 1 && args[0] == "--breakdown" {
+				printCriticalityBreakdown(entries)
+				break
+			}
+			var pred func(Entry) bool
+			if len(args) > 0 {
+				if pred, err = ParseFilter(strings.Join(args, " ")); err != nil {
+					fail("Error parsing filter:", err)
+					break
+				}
+			}
+			fmt.Println(CountEntries(entries, pred))
+		case "get":
+			var fixletID int
+			fmt.Println("Enter FixletID to get:")
+			fmt.Fscanln(stdin, &fixletID)
+			if e, found := lookup().Get(fixletID); found {
+				PrintEntryDetails(*e, os.Stdout)
+			} else {
+				fmt.Printf("No entry with FixletID %d.\n", fixletID)
+			}
+		case "stats":
+			if err := PrintStats(Stats(entries), opts.OutputFormat, os.Stdout); err != nil {
+				fail("Error printing stats:", err)
+			}
+		case "validate":
+			strict := opts.Strict || slices.Contains(args, "--strict")
+			if err := runValidate(entries, strict); err != nil {
+				fail(err)
+			}
+		case "alert":
+			var threshold int
+			fmt.Println("Enter the RelevantComputerCount threshold:")
+			if _, err := fmt.Fscanln(stdin, &threshold); err != nil {
+				fail("Invalid threshold:", err)
+				break
+			}
+			printAlerts(entries, threshold)
+		case "count-by", "freq":
+			// freq is the older name of count-by.
+			var field string
+			if len(args) > 0 {
+				field = args[0]
+			} else {
+				fmt.Println("Enter field to group by (SiteID, FixletID, Name, Criticality, RelevantComputerCount):")
+				field, _ = readLine()
+			}
+			buckets := opts.Buckets
+			if len(args) > 1 {
+				if buckets, err = ParseBuckets(args[1]); err != nil {
+					fail("Error parsing buckets:", err)
+					break
+				}
+			}
+			var counts map[string]int
+			if name, ok := CanonicalField(field); ok && isNumericField(name) && len(buckets) > 0 {
+				counts, err = FieldFrequencyBuckets(entries, name, buckets)
+			} else {
+				counts, err = CountByField(entries, field)
+			}
+			if err != nil {
+				fail("Error counting entries:", err)
+				break
+			}
+			PrintFrequency(counts, os.Stdout)
+		case "schema":
+			PrintSchema(Schema(), os.Stdout)
+		case "aliases":
+			PrintAliases(os.Stdout)
+		case "file-info":
+			if err := printFileInfo(inputFiles(opts), entries); err != nil {
+				fail("Error reading file info:", err)
+			}
+		case "describe":
+			if err := PrintDescribe(DescribeNumeric(entries), opts.OutputFormat, os.Stdout); err != nil {
+				fail("Error describing entries:", err)
+			}
+		case "crosstab":
+			PrintCrossTab(CrossTab(entries), os.Stdout)
+		case "pivot":
+			fmt.Println("Enter output CSV filename (leave empty for stdout):")
+			out, _ := readLine()
+			headers, rows := PivotBySiteID(entries)
+			if out == "" {
+				err = writePivotCSV(os.Stdout, headers, rows)
+			} else {
+				err = WriteCSVPivot(out, headers, rows)
+			}
+			if err != nil {
+				fail("Error writing pivot table:", err)
+			} else if out != "" {
+				info("Pivot table written to", out)
+			}
+		case "site-report":
+			if err := PrintSiteReport(SiteReport(entries), opts.OutputFormat, os.Stdout); err != nil {
+				fail("Error printing site report:", err)
+			}
+		case "sum-computers":
+			if err := PrintComputerSums(SumComputersByCriticality(entries), "Criticality", opts.OutputFormat, os.Stdout); err != nil {
+				fail("Error printing computer totals:", err)
+			}
+		case "sum-computers-site":
+			if err := PrintComputerSums(SumComputersBySiteID(entries), "SiteID", opts.OutputFormat, os.Stdout); err != nil {
+				fail("Error printing computer totals:", err)
+			}
+		case "add":
+			e, err := readNewEntry(entries, opts.Add)
+			if err == nil {
+				err = lookup().Add(e)
+			}
+			if err != nil {
+				fail("Error adding entry:", err)
+				break
+			}
+			before := slices.Clone(entries)
+			entries = index.Entries()
+			commit("add", before)
+			info("Entry added.")
+		case "upsert":
+			var e Entry
+			fmt.Println("Enter SiteID, FixletID, Name, Criticality, RelevantComputerCount:")
+			if _, err := fmt.Fscanf(stdin, "%d %d %s %s %d\n", &e.SiteID, &e.FixletID, &e.Name, &e.Criticality, &e.RelevantComputerCount); err != nil {
+				fail("Error upserting entry:", err)
+				break
+			}
+			if opts.Add.NormalizeNames {
+				e.Name = NormalizeName(e.Name)
+			}
+			before := slices.Clone(entries)
+			var result UpsertResult
+			entries, result, err = UpsertEntry(entries, e)
+			if err != nil {
+				fail("Error upserting entry:", err)
+				break
+			}
+			if result.Inserted || len(result.FieldDiff) > 0 {
+				commit("upsert", before)
+			}
+			printUpsertResult(result)
+		case "delete":
+			var fixletID int
+			fmt.Println("Enter FixletID to delete:")
+			fmt.Fscanln(stdin, &fixletID)
+			// Deleting leaves the current slice as it was, for undo.
+			before := entries
+			if lookup().Delete(fixletID) {
+				entries = index.Entries()
+				commit("delete", before)
+				info("Entry deleted.")
+			} else {
+				fail("Entry not found.")
+			}
+		case "orphan-sites":
+			sites := FindSingletonSites(entries)
+			printOrphanSites(entries, sites)
+			if !opts.PruneOrphans && !slices.Contains(args, "--delete-orphans") {
+				break
+			}
+			before := entries
+			var deleted bool
+			if entries, deleted = deleteOrphans(entries, sites); deleted {
+				commit("delete", before)
+				infof("%d entries deleted.\n", len(sites))
+			} else if len(sites) > 0 {
+				fmt.Println("Nothing deleted.")
+			}
+		case "delete-filter":
+			expr := strings.Join(args, " ")
+			if expr == "" {
+				fmt.Println("Enter filter expression for entries to delete (e.g. SiteID=3):")
+				expr, _ = readLine()
+			}
+			pred, err := ParseFilter(expr)
+			if err != nil {
+				fail("Error parsing filter:", err)
+				break
+			}
+			kept, n := DeleteByFilter(entries, pred)
+			if n == 0 {
+				fmt.Println("No entries match.")
+				break
+			}
+			if !confirm(fmt.Sprintf("Delete %d entries?", n)) {
+				fmt.Println("Nothing deleted.")
+				break
+			}
+			before := entries
+			entries = kept
+			commit("delete", before)
+			infof("%d entries deleted.\n", n)
+		case "update":
+			var fixletID int
+			var patch Entry
+			fmt.Println("Enter FixletID to update:")
+			fmt.Fscanln(stdin, &fixletID)
+			fmt.Println("Enter SiteID, Name, Criticality, RelevantComputerCount (0 or - keeps the current value):")
+			if _, err := fmt.Fscanf(stdin, "%d %s %s %d\n", &patch.SiteID, &patch.Name, &patch.Criticality, &patch.RelevantComputerCount); err != nil {
+				fail("Error updating entry:", err)
+				break
+			}
+			if patch.Name == "-" {
+				patch.Name = ""
+			}
+			if patch.Criticality == "-" {
+				patch.Criticality = ""
+			}
+			before := slices.Clone(entries)
+			var changes FieldDiff
+			var found bool
+			entries, changes, found, err = PatchEntry(entries, fixletID, patch)
+			switch {
+			case err != nil:
+				fail("Error updating entry:", err)
+			case !found:
+				fail("Entry not found.")
+			case len(changes) == 0:
+				fmt.Println("No fields changed.")
+			default:
+				fmt.Print(changes)
+				if !confirm("Save these changes?") {
+					entries = before
+					fmt.Println("Update discarded.")
+					break
+				}
+				commit("update", before)
+				info("Entry updated.")
+			}
+		case "copy":
+			var srcID, newID int
+			fmt.Println("Enter FixletID to copy:")
+			fmt.Fscanln(stdin, &srcID)
+			fmt.Println("Enter FixletID for the copy (0 assigns the next free one):")
+			fmt.Fscanln(stdin, &newID)
+			before := slices.Clone(entries)
+			if entries, err = CopyEntry(entries, srcID, newID); err != nil {
+				fail("Error copying entry:", err)
+				break
+			}
+			commit("add", before)
+			info("Entry copied: " + formatEntry(entries[len(entries)-1]))
+		case "bench":
+			result, err := BenchmarkReadWrite(opts.File, opts.Iterations, opts.CSV)
+			if err != nil {
+				fail("Error running benchmark:", err)
+				break
+			}
+			PrintBenchmark(result, os.Stdout)
+		case "check-encoding":
+			fmt.Println("Enter the file to check (leave empty for the current file):")
+			name, _ := readLine()
+			if name == "" {
+				name = opts.File
+			}
+			enc, err := DetectEncoding(name)
+			if err != nil {
+				fail("Error checking encoding:", err)
+				break
+			}
+			fmt.Printf("%s: %s\n", name, enc)
+		case "convert-encoding":
+			fmt.Println("Enter the file to convert (leave empty for the current file):")
+			src, _ := readLine()
+			if src == "" {
+				src = opts.File
+			}
+			fmt.Println("Enter the output filename (leave empty to overwrite it):")
+			dst, _ := readLine()
+			if dst == "" {
+				dst = src
+			}
+			fmt.Printf("Enter the current encoding (%s; leave empty to detect it):\n", strings.Join(Encodings, ", "))
+			from, _ := readLine()
+			fmt.Println("Enter the new encoding (leave empty for utf-8):")
+			to, _ := readLine()
+			if to == "" {
+				to = "utf-8"
+			}
+			if err := ConvertEncoding(src, dst, from, to); err != nil {
+				fail("Error converting encoding:", err)
+				break
+			}
+			infof("%s converted to %s.\n", dst, to)
+			if dst == opts.File {
+				fmt.Println("Restart the session to load the converted file.")
+			}
+		case "repair":
+			// The repaired rows come from the file, replacing the session's.
+			if dirty && !confirm("Discard the changes that have not been saved?") {
+				fmt.Println("Repair cancelled.")
+				break
+			}
+			repaired, _, n, err := repairEntries(opts.File, opts.CSV)
+			if err != nil {
+				fail("Error running repair:", err)
+				break
+			}
+			before := entries
+			entries = repaired
+			commit("repair", before)
+			infof("%d rows repaired; save to write them to %s.\n", n, opts.File)
+		case "compact":
+			// The compacted rows come from the file, replacing the session's.
+			if dirty && !confirm("Discard the changes that have not been saved?") {
+				fmt.Println("Compact cancelled.")
+				break
+			}
+			compacted, rowErrors, n, err := compactEntries(opts.File, opts.CSV)
+			if err != nil {
+				fail("Error running compact:", err)
+				break
+			}
+			for _, rowErr := range rowErrors {
+				fmt.Println("Warning:", rowErr)
+			}
+			before := entries
+			entries = compacted
+			commit("compact", before)
+			infof("%d blank rows removed; save to write the result to %s.\n", n, opts.File)
+		case "save":
+			persist()
+			if failure == nil && !opts.DryRun && !opts.Stdout {
+				infof("Saved %d entries to %s.\n", len(entries), opts.File)
+			}
+		case "save-as":
+			fmt.Println("Enter the filename to save to:")
+			name, _ := readLine()
+			if name == "" {
+				fmt.Println("No filename given.")
+				break
+			}
+			// The session keeps working on opts.File.
+			other := opts
+			other.File = name
+			if err := saveEntries(entries, other); err != nil {
+				fail("Error saving CSV file:", err)
+			} else if !opts.DryRun {
+				infof("Saved %d entries to %s.\n", len(entries), name)
+			}
+		case "reload":
+			if dirty && !confirm("Discard the changes that have not been saved?") {
+				fmt.Println("Reload cancelled.")
+				break
+			}
+			reload()
+		case "gen":
+			fmt.Println("Enter output CSV filename:")
+			out, _ := readLine()
+			if out == "" {
+				fmt.Println("No filename given.")
+				break
+			}
+			if err := writeFixtures(out, opts); err != nil {
+				fail("Error generating entries:", err)
+				break
+			}
+			infof("%d entries written to %s (seed %d).\n", opts.Count, out, opts.Seed)
+		case "export-json":
+			var out string
+			fmt.Println("Enter output JSON filename:")
+			fmt.Fscanln(stdin, &out)
+			export := ExportJSON
+			if len(opts.Columns) > 0 {
+				export = func(entries []Entry, filename string) error {
+					return ExportJSONColumns(entries, opts.Columns, filename)
+				}
+			}
+			if err := export(entries, out); err != nil {
+				fail("Error exporting JSON:", err)
+			} else {
+				info("Entries exported.")
+			}
+		case "export-md":
+			fmt.Println("Enter output Markdown filename (leave empty for stdout):")
+			out, _ := readLine()
+			columns := opts.Columns
+			if len(columns) == 0 {
+				columns = FieldNames
+			}
+			err := writeToFileOrStdout(out, func(w io.Writer) error { return ExportMarkdownColumns(entries, columns, w) })
+			if err != nil {
+				fail("Error exporting Markdown:", err)
+			} else if out != "" {
+				info("Entries exported.")
+			}
+		case "export-sql":
+			fmt.Println("Enter output SQL filename (leave empty for stdout):")
+			out, _ := readLine()
+			export := ExportSQL
+			if opts.Upsert {
+				export = ExportSQLUpsert
+			}
+			err := writeToFileOrStdout(out, func(w io.Writer) error { return export(entries, opts.Table, w) })
+			if err != nil {
+				fail("Error exporting SQL:", err)
+			} else if out != "" {
+				info("Entries exported.")
+			}
+		case "export-html":
+			fmt.Println("Enter output HTML filename (leave empty for stdout):")
+			out, _ := readLine()
+			export := ExportHTML
+			if opts.Standalone {
+				export = ExportHTMLPage
+			}
+			err := writeToFileOrStdout(out, func(w io.Writer) error { return export(entries, w) })
+			if err != nil {
+				fail("Error exporting HTML:", err)
+			} else if out != "" {
+				info("Entries exported.")
+			}
+		case "import-json":
+			var src, mode string
+			fmt.Println("Enter source JSON filename:")
+			fmt.Fscanln(stdin, &src)
+			imported, err := ImportJSON(src)
+			if err != nil {
+				fail("Error importing JSON:", err)
+				break
+			}
+			fmt.Println("Replace or append to current entries? (replace/append):")
+			fmt.Fscanln(stdin, &mode)
+			if mode != "replace" && mode != "append" {
+				fail("Invalid mode.")
+				break
+			}
+			if mode == "replace" {
+				before := entries
+				entries = imported
+				commit("import", before)
+				infof("%d entries imported.\n", len(imported))
+				break
+			}
+			fmt.Println("Enter merge strategy for existing FixletIDs (skip/overwrite/fail):")
+			answer, _ := readLine()
+			strategy, err := ParseMergeStrategy(answer)
+			if err != nil {
+				fail("Error importing JSON:", err)
+				break
+			}
+			merged, report := MergeEntries(entries, imported, strategy)
+			if strategy == MergeFail && report.Conflicted > 0 {
+				fail("Error importing JSON:", report.conflictError())
+				break
+			}
+			if report.Added+report.Replaced > 0 {
+				before := entries
+				entries = merged
+				commit("import", before)
+			}
+			infof("%d added, %d skipped, %d overwritten, %d conflicted.\n", report.Added, report.Skipped, report.Replaced, report.Conflicted)
+		case "import-csv", "merge":
+			// merge is another name for import-csv.
+			fmt.Println("Enter source CSV filename:")
+			src, _ := readLine()
+			fmt.Println("Enter merge strategy for existing FixletIDs (skip/overwrite/fail):")
+			answer, _ := readLine()
+			strategy, err := ParseMergeStrategy(answer)
+			if err != nil {
+				fail("Error importing CSV:", err)
+				break
+			}
+			// Ctrl-C during a long import cancels just the import.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			merged, report, err := ImportCSVContext(ctx, entries, src, strategy, opts.CSV)
+			stop()
+			for _, rowErr := range report.RowErrors {
+				fmt.Println("Warning:", rowErr)
+			}
+			if err != nil {
+				fail("Error importing CSV:", err)
+				break
+			}
+			if report.Added+report.Overwritten > 0 {
+				before := entries
+				entries = merged
+				commit("import", before)
+			}
+			infof("%d added, %d skipped, %d overwritten, %d conflicted.\n", report.Added, report.Skipped, report.Overwritten, report.Conflicted)
+		case "id-gaps":
+			printIDGaps(entries)
+		case "compact-ids":
+			compacted := CompactIDs(entries)
+			if slices.Equal(compacted, entries) {
+				fmt.Println("FixletIDs are already contiguous from 1.")
+				break
+			}
+			if !confirm("Renumber every FixletID from 1?") {
+				fmt.Println("Nothing renumbered.")
+				break
+			}
+			before := entries
+			entries = compacted
+			commit("compact-ids", before)
+			infof("%d entries renumbered.\n", len(entries))
+		case "dedup":
+			name := opts.OnConflict
+			if len(args) > 0 {
+				name = args[0]
+			}
+			resolve, err := ParseResolver(name)
+			if err != nil {
+				fail("Error deduplicating:", err)
+				break
+			}
+			dups := FindDuplicates(entries)
+			if len(dups) == 0 {
+				fmt.Println("No duplicates found.")
+				break
+			}
+			for _, group := range dups {
+				kept := group[0]
+				for _, e := range group[1:] {
+					kept = resolve(kept, e)
+				}
+				fmt.Printf("FixletID %d appears %d times, keeping: %s\n", kept.FixletID, len(group), formatEntry(kept))
+				i := slices.Index(group, kept)
+				for _, e := range slices.Delete(slices.Clone(group), i, i+1) {
+					fmt.Println("  removed: " + formatEntry(e))
+				}
+			}
+			before := entries
+			var removed int
+			entries, removed = DeduplicateEntries(entries, resolve)
+			commit("delete", before)
+			infof("%d duplicate entries removed.\n", removed)
+		case "undo":
+			previous, ok := undo.Pop()
+			if !ok {
+				fmt.Println("Nothing to undo.")
+				break
+			}
+			before := entries
+			entries = previous
+			record("undo", before)
+			modified()
+			info("Last change undone.")
+		case "diff":
+			other := strings.Join(args, " ")
+			if other == "" {
+				fmt.Println("Enter CSV filename to compare against:")
+				other, _ = readLine()
+			}
+			otherEntries, _, _, err := ReadCSV(other, opts.CSV)
+			if err != nil {
+				fail("Error reading CSV file:", err)
+				break
+			}
+			PrintDiff(DiffEntries(entries, otherEntries), os.Stdout)
+		case "group-site":
+			PrintGrouped(GroupBySiteID(entries), os.Stdout)
+		case "split-site":
+			fmt.Println("Enter the output directory:")
+			dir, _ := readLine()
+			if dir == "" {
+				fmt.Println("No directory given.")
+				break
+			}
+			files, err := SplitBySiteID(entries, dir, opts.CSV)
+			if err != nil {
+				fail("Error splitting entries:", err)
+			}
+			printSplitSummary(files, GroupBySiteID(entries))
+		case "split-criticality":
+			fmt.Println("Enter the output directory:")
+			dir, _ := readLine()
+			if dir == "" {
+				fmt.Println("No directory given.")
+				break
+			}
+			files, err := SplitByCriticality(entries, dir, opts.Overwrite, opts.CSV)
+			if err != nil {
+				fail("Error splitting entries:", err)
+			}
+			printSplitSummary(files, GroupByCriticality(entries))
+		case "group-criticality":
+			PrintGroupedByCriticality(GroupByCriticality(entries), os.Stdout)
+		case "rename-site":
+			var oldID, newID int
+			fmt.Println("Enter the SiteID to rename:")
+			fmt.Fscanln(stdin, &oldID)
+			fmt.Println("Enter the new SiteID:")
+			fmt.Fscanln(stdin, &newID)
+			if newID <= 0 {
+				fail("Invalid SiteID:", newID)
+				break
+			}
+			if !opts.Merge && newID != oldID && slices.ContainsFunc(entries, func(e Entry) bool { return e.SiteID == newID }) {
+				fmt.Printf("SiteID %d already exists; use --merge to combine the sites.\n", newID)
+				break
+			}
+			renamed, n := RenameSiteID(entries, oldID, newID)
+			if n == 0 {
+				fmt.Println("No entries match.")
+				break
+			}
+			if !confirm(fmt.Sprintf("Rename SiteID %d to %d on %d entries?", oldID, newID, n)) {
+				fmt.Println("Nothing renamed.")
+				break
+			}
+			before := entries
+			entries = renamed
+			commit("update", before)
+			infof("%d entries updated.\n", n)
+		case "replace-name":
+			useRegex := slices.Contains(args, "--regex")
+			if useRegex {
+				fmt.Println("Enter the regular expression to search for:")
+			} else {
+				fmt.Println("Enter the text to search for:")
+			}
+			search, _ := readLine()
+			fmt.Println("Enter the replacement:")
+			repl, _ := readLine()
+			var replaced []Entry
+			var n int
+			if useRegex {
+				if replaced, n, err = ReplaceNameRegex(entries, search, repl); err != nil {
+					fail("Error replacing names:", err)
+					break
+				}
+			} else {
+				replaced, n = ReplaceInName(entries, search, repl, confirm("Case-sensitive?"))
+			}
+			if n == 0 {
+				fmt.Println("No names match.")
+				break
+			}
+			if !confirm(fmt.Sprintf("Replace in %d names?", n)) {
+				fmt.Println("Nothing replaced.")
+				break
+			}
+			before := entries
+			entries = replaced
+			commit("update", before)
+			infof("%d entries updated.\n", n)
+		case "trim":
+			trimmed, n := TrimEntries(entries)
+			if n == 0 {
+				fmt.Println("No entries needed trimming.")
+				break
+			}
+			before := entries
+			entries = trimmed
+			commit("update", before)
+			infof("%d entries cleaned.\n", n)
+		case "case-variants":
+			variants := FindCaseVariants(entries)
+			if len(variants) == 0 {
+				fmt.Println("No case variants found.")
+				break
+			}
+			for _, group := range variants {
+				fmt.Printf("%s:\n", NormalizeName(group[0].Name))
+				for _, e := range group {
+					fmt.Println("  " + formatEntry(e))
+				}
+			}
+		case "profile":
+			if len(args) == 0 || (args[0] != "list" && len(args) < 2) {
+				fmt.Println("Usage: profile list | profile set <name> [csv-file] | profile use <name> | profile delete <name>")
+				break
+			}
+			path := DefaultProfilesPath()
+			store, err := LoadProfiles(path)
+			if err != nil {
+				fail("Error loading profiles:", err)
+				break
+			}
+			switch args[0] {
+			case "list":
+				PrintProfiles(store, os.Stdout)
+			case "set":
+				profile := activeConfig(opts)
+				if len(args) > 2 {
+					profile.CSVFile = args[2]
+				}
+				// Profiles are used from any directory, so store an absolute path.
+				if abs, err := filepath.Abs(profile.CSVFile); err == nil {
+					profile.CSVFile = abs
+				}
+				store.Profiles[args[1]] = profile
+				if err := SaveProfiles(path, store); err != nil {
+					fail("Error saving profiles:", err)
+					break
+				}
+				infof("Profile %s saved with CSV file %s.\n", args[1], profile.CSVFile)
+			case "delete":
+				if _, ok := store.Profiles[args[1]]; !ok {
+					fail("No such profile:", args[1])
+					break
+				}
+				delete(store.Profiles, args[1])
+				if store.Active == args[1] {
+					store.Active = ""
+				}
+				if err := SaveProfiles(path, store); err != nil {
+					fail("Error saving profiles:", err)
+					break
+				}
+				infof("Profile %s deleted.\n", args[1])
+			case "use":
+				profile, ok := store.Profiles[args[1]]
+				if !ok {
+					fail("No such profile:", args[1])
+					break
+				}
+				if dirty && !opts.Watch && !confirm("Discard the changes that have not been saved?") {
+					fmt.Println("Profile not changed.")
+					break
+				}
+				store.Active = args[1]
+				if err := SaveProfiles(path, store); err != nil {
+					fail("Error saving profiles:", err)
+					break
+				}
+				if opts.Watch {
+					fmt.Printf("Profile %s is active; restart to watch its CSV file.\n", args[1])
+					break
+				}
+				// The profile names the one file the session works on.
+				switched := opts
+				switched.Inputs = nil
+				if err := applyConfig(&switched, profile, func(string) bool { return false }); err != nil {
+					fail("Error in profile:", err)
+					break
+				}
+				loaded, _, rowErrors, err := ReadCSV(switched.File, switched.CSV)
+				if err != nil {
+					fail("Error reading CSV file:", err)
+					break
+				}
+				for _, rowErr := range rowErrors {
+					fmt.Println("Warning:", rowErr)
+				}
+				opts, entries = switched, loaded
+				audit = NewAuditLogger(AuditLogPath(opts.File))
+				undo.Clear()
+				dirty = false
+				pending = nil
+				infof("Using profile %s: %d entries from %s.\n", args[1], len(entries), opts.File)
+			default:
+				fail("Unknown profile command:", args[0])
+			}
+		case "config":
+			if len(args) != 1 || args[0] != "show" {
+				fmt.Println("Usage: config show")
+				break
+			}
+			PrintConfig(activeConfig(opts), os.Stdout)
+		case "audit":
+			n := 20
+			if len(args) > 0 {
+				if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+					fail("Invalid number:", args[0])
+					break
+				}
+			}
+			events, err := ReadAuditLog(audit.Path, n)
+			if err != nil {
+				fail("Error reading audit log:", err)
+				break
+			}
+			PrintAuditEvents(events, os.Stdout)
+		case "restore":
+			backups, err := ListBackups(opts.File)
+			if err != nil {
+				fail("Error listing backups:", err)
+				break
+			}
+			if len(backups) == 0 {
+				fmt.Println("No backups found.")
+				break
+			}
+			for i, b := range backups {
+				fmt.Printf("%d) %s\n", i+1, b)
+			}
+			fmt.Println("Enter the number of the backup to restore:")
+			var choice int
+			fmt.Fscanln(stdin, &choice)
+			if choice < 1 || choice > len(backups) {
+				fail("Invalid choice.")
+				break
+			}
+			restored, _, rowErrors, err := ReadCSV(backups[choice-1], opts.CSV)
+			if err != nil {
+				fail("Error reading backup:", err)
+				break
+			}
+			for _, rowErr := range rowErrors {
+				fmt.Println("Warning:", rowErr)
+			}
+			undo.Clear()
+			before := entries
+			entries = restored
+			record("restore", before)
+			modified()
+			infof("Restored %d entries from %s.\n", len(entries), backups[choice-1])
+		case "exit":
+			finish()
+			undo.Clear()
+			if lineAt == nil {
+				info("Exiting program.")
+			}
+			return entries, results
+		default:
+			fail("Invalid command.")
+		}
+		if lineAt != nil {
+			results = append(results, ScriptResult{lineNo, line, changed, failure})
 		}
 	}
 }
@@ -0,0 +1,892 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkRows is the size of the datasets the index benchmarks run on.
+const benchmarkRows = 100000
+
+// spread returns the i-th of a sequence of positions scattered over n rows,
+// so lookups do not all hit the start of the slice.
+func spread(i, n int) int {
+	return i * 48271 % n
+}
+
+func BenchmarkGet(b *testing.B) {
+	entries := GenerateFixtures(benchmarkRows, 1)
+	index := NewEntryIndex(entries)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found := index.Get(entries[spread(i, len(entries))].FixletID); !found {
+			b.Fatal("entry not found")
+		}
+	}
+}
+
+func BenchmarkGetEntry(b *testing.B) {
+	entries := GenerateFixtures(benchmarkRows, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found := GetEntry(entries, entries[spread(i, len(entries))].FixletID); !found {
+			b.Fatal("entry not found")
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	entries := GenerateFixtures(benchmarkRows, 1)
+	index := NewEntryIndex(entries)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%len(entries) == 0 {
+			b.StopTimer()
+			index = NewEntryIndex(entries)
+			b.StartTimer()
+		}
+		if !index.Delete(entries[i%len(entries)].FixletID) {
+			b.Fatal("entry not found")
+		}
+	}
+}
+
+func BenchmarkDeleteEntry(b *testing.B) {
+	entries := GenerateFixtures(benchmarkRows, 1)
+	work := make([]Entry, len(entries))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		copy(work, entries)
+		b.StartTimer()
+		if _, found := DeleteEntry(work, entries[i%len(entries)].FixletID); !found {
+			b.Fatal("entry not found")
+		}
+	}
+}
+
+func TestEntryIndex(t *testing.T) {
+	entries := []Entry{
+		{1, 10, "A", "High", 1, ""},
+		{1, 20, "B", "High", 2, ""},
+		{1, 10, "C", "High", 3, ""},
+		{1, 30, "D", "High", 4, ""},
+	}
+	index := NewEntryIndex(slices.Clone(entries))
+	if e, found := index.Get(10); !found || e.Name != "A" {
+		t.Fatalf("Get(10) = %v, %v; want the first occurrence", e, found)
+	}
+	if !index.Delete(10) {
+		t.Fatal("Delete(10) = false")
+	}
+	if e, found := index.Get(10); !found || e.Name != "C" {
+		t.Fatalf("after Delete, Get(10) = %v, %v; want the later duplicate", e, found)
+	}
+	if err := index.Add(Entry{1, 40, "E", "High", 5, ""}); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Add(Entry{1, 20, "F", "High", 6, ""}); err == nil {
+		t.Error("Add of an existing FixletID succeeded")
+	}
+	var names []string
+	for _, e := range index.Entries() {
+		names = append(names, e.Name)
+	}
+	if want := []string{"B", "C", "D", "E"}; !slices.Equal(names, want) {
+		t.Errorf("Entries() names = %v, want %v", names, want)
+	}
+	if e, found := index.Get(30); !found || e.Name != "D" {
+		t.Errorf("after Entries, Get(30) = %v, %v", e, found)
+	}
+}
+
+func TestReadTSV(t *testing.T) {
+	entries, rowErrors, err := ReadTSV(filepath.Join("testdata", "fixlets.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rowErrors) > 0 {
+		t.Fatalf("unexpected row errors: %v", rowErrors)
+	}
+	want := []Entry{
+		{1, 101, "Kernel Update, x64", "Critical", 120, ""},
+		{2, 102, "Driver\tPack", "High", 7, ""},
+		{3, 103, `Quoted "Name"`, "Low", 0, ""},
+	}
+	if !slices.Equal(entries, want) {
+		t.Errorf("ReadTSV() = %v, want %v", entries, want)
+	}
+}
+
+func TestTSVRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+	}{
+		{"plain", []Entry{{1, 1, "Update", "High", 5, ""}, {2, 2, "Patch", "Low", 0, ""}}},
+		{"tab in name", []Entry{{1, 1, "Driver\tPack", "Critical", 3, ""}}},
+		{"comma and quotes", []Entry{{1, 1, `KB1, "x64"`, "Medium", 9, ""}}},
+		{"newline in name", []Entry{{1, 1, "two\nlines", "Moderate", 1, ""}}},
+		{"notes", []Entry{{1, 1, "Update", "High", 5, "checked\tby ops"}, {1, 2, "Patch", "Low", 0, ""}}},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "fixlets.tsv")
+			if err := WriteTSV(filename, tt.entries); err != nil {
+				t.Fatal(err)
+			}
+			if !looksLikeTSV(filename) {
+				t.Error("written file is not recognized as tab-separated")
+			}
+			got, rowErrors, err := ReadTSV(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rowErrors) > 0 {
+				t.Fatalf("unexpected row errors: %v", rowErrors)
+			}
+			if !slices.Equal(got, tt.entries) {
+				t.Errorf("read back %v, want %v", got, tt.entries)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomicInterrupted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fixlets.csv")
+	original := []Entry{{1, 1, "Update", "High", 5, ""}, {2, 2, "Patch", "Low", 0, ""}}
+	if err := WriteCSV(filename, original, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The write stops halfway through, as if the disk filled up or the
+	// program was killed.
+	errInterrupted := errors.New("interrupted")
+	err = writeFileAtomic(filename, func(w io.Writer) error {
+		if err := writeCSVRecords(w, GenerateFixtures(1000, 1)[:500], CSVOptions{}); err != nil {
+			return err
+		}
+		return errInterrupted
+	})
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("writeFileAtomic() error = %v, want %v", err, errInterrupted)
+	}
+	after, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("file changed by the interrupted write:\n%s", after)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+	entries, _, _, err := ReadCSV(filename, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(entries, original) {
+		t.Errorf("ReadCSV() = %v, want %v", entries, original)
+	}
+}
+
+func TestSortEntriesMultiStable(t *testing.T) {
+	// FixletID records the original position; every test ties on its
+	// primary key for some entries.
+	entries := []Entry{
+		{2, 1, "Beta", "High", 10, ""},
+		{1, 2, "Alpha", "Low", 10, ""},
+		{2, 3, "Alpha", "High", 5, ""},
+		{1, 4, "Beta", "Low", 5, ""},
+		{2, 5, "Alpha", "Low", 10, ""},
+		{1, 6, "Beta", "High", 5, ""},
+	}
+	tests := []struct {
+		name string
+		keys []SortKey
+		want []int // FixletIDs in sorted order
+	}{
+		{"name ties keep order", []SortKey{{"Name", false}}, []int{2, 3, 5, 1, 4, 6}},
+		{"descending ties keep order", []SortKey{{"RelevantComputerCount", true}}, []int{1, 2, 5, 3, 4, 6}},
+		{"secondary key", []SortKey{{"Name", false}, {"Computers", true}}, []int{2, 5, 3, 1, 4, 6}},
+		{"ties on every key", []SortKey{{"SiteID", false}, {"Criticality", false}}, []int{6, 2, 4, 1, 3, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := slices.Clone(entries)
+			if err := SortEntriesMulti(sorted, tt.keys); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int, len(sorted))
+			for i, e := range sorted {
+				got[i] = e.FixletID
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SortEntriesMulti(%v) order = %v, want %v", tt.keys, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortEntriesStable(t *testing.T) {
+	entries := GenerateFixtures(200, 3)
+	for i := range entries {
+		entries[i].FixletID = i
+	}
+	if err := SortEntries(entries, "Criticality", false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Criticality == entries[i].Criticality && entries[i-1].FixletID > entries[i].FixletID {
+			t.Fatalf("entries %d and %d with Criticality %s were reordered", entries[i-1].FixletID, entries[i].FixletID, entries[i].Criticality)
+		}
+	}
+}
+
+// displayEntries are the entries the output tests display.
+var displayEntries = []Entry{
+	{1, 101, "Kernel Update", "Critical", 120, ""},
+	{2, 102, "Driver Pack", "Low", 7, "retest"},
+}
+
+func TestDisplayFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		display func(w io.Writer)
+		want    string
+	}{
+		{
+			"ListEntries",
+			func(w io.Writer) { ListEntries(displayEntries, w) },
+			"SiteID: 1, FixletID: 101, Name: Kernel Update, Criticality: Critical, Computers: 120\n" +
+				"SiteID: 2, FixletID: 102, Name: Driver Pack, Criticality: Low, Computers: 7, Notes: retest\n",
+		},
+		{
+			"ListEntries empty",
+			func(w io.Writer) { ListEntries(nil, w) },
+			"No entries available.\n",
+		},
+		{
+			"ListEntriesPage",
+			func(w io.Writer) { ListEntriesPage(displayEntries, 2, 1, w) },
+			"SiteID: 2, FixletID: 102, Name: Driver Pack, Criticality: Low, Computers: 7, Notes: retest\n" +
+				"Page 2/2 (entries 2-2 of 2)\n",
+		},
+		{
+			"ListEntriesPage out of range",
+			func(w io.Writer) { ListEntriesPage(displayEntries, 3, 1, w) },
+			"Page 3 is out of range (2 pages).\n",
+		},
+		{
+			"QueryEntry",
+			func(w io.Writer) { QueryEntry(displayEntries, "kernel", w) },
+			"SiteID: 1, FixletID: 101, Name: Kernel Update, Criticality: Critical, Computers: 120\n",
+		},
+		{
+			"QueryEntry no match",
+			func(w io.Writer) { QueryEntry(displayEntries, "browser", w) },
+			"No entries found.\n",
+		},
+		{
+			"PrintEntryDetails",
+			func(w io.Writer) { PrintEntryDetails(displayEntries[1], w) },
+			"SiteID: 2\nFixletID: 102\nName: Driver Pack\nCriticality: Low\nRelevantComputerCount: 7\nNotes: retest\n",
+		},
+		{
+			"PrintDiff",
+			func(w io.Writer) {
+				changed := displayEntries[1]
+				changed.RelevantComputerCount = 8
+				PrintDiff(DiffEntries(displayEntries, []Entry{changed}), w)
+			},
+			"- SiteID: 1, FixletID: 101, Name: Kernel Update, Criticality: Critical, Computers: 120\n" +
+				"- SiteID: 2, FixletID: 102, Name: Driver Pack, Criticality: Low, Computers: 7, Notes: retest\n" +
+				"+ SiteID: 2, FixletID: 102, Name: Driver Pack, Criticality: Low, Computers: 8, Notes: retest\n" +
+				"1 removed, 0 added, 1 changed.\n",
+		},
+		{
+			"PrintDiff no differences",
+			func(w io.Writer) { PrintDiff(DiffEntries(displayEntries, displayEntries), w) },
+			"No differences.\n",
+		},
+		{
+			"PrintAuditEvents",
+			func(w io.Writer) {
+				PrintAuditEvents([]AuditEvent{{
+					Timestamp: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+					Operation: "delete",
+					User:      "alice",
+					FxiletID:  101,
+					Before:    &displayEntries[0],
+				}}, w)
+			},
+			"2024-01-15T14:30:00Z  alice  delete  FixletID 101\n" +
+				"  before: SiteID: 1, FixletID: 101, Name: Kernel Update, Criticality: Critical, Computers: 120\n",
+		},
+		{
+			"PrintAuditEvents empty",
+			func(w io.Writer) { PrintAuditEvents(nil, w) },
+			"The audit log is empty.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.display(&buf)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	var text bytes.Buffer
+	if err := PrintStats(Stats(displayEntries), "text", &text); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Total entries: 2\n", "  Critical: 1\n", "Computers: min 7, max 120, avg 63.50\n", "  SiteID 1: 1 fixlets, 120 computers\n"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report does not contain %q:\n%s", want, text.String())
+		}
+	}
+	var data bytes.Buffer
+	if err := PrintStats(Stats(displayEntries), "json", &data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(data.String(), `"Total": 2`) {
+		t.Errorf("JSON report does not contain the total:\n%s", data.String())
+	}
+	if err := PrintStats(Stats(displayEntries), "xml", io.Discard); err == nil {
+		t.Error("PrintStats accepted an unknown format")
+	}
+}
+
+func TestPrintEntries(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"text", "SiteID: 1, FixletID: 101, Name: Kernel Update, Criticality: Critical, Computers: 120\n"},
+		{"jsonl", `{"SiteID":1,"FixletID":101,"Name":"Kernel Update","Criticality":"Critical","RelevantComputerCount":120}` + "\n"},
+		{"json", "[\n  {\n    \"SiteID\": 1,\n    \"FixletID\": 101,\n    \"Name\": \"Kernel Update\",\n    \"Criticality\": \"Critical\",\n    \"RelevantComputerCount\": 120\n  }\n]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := PrintEntries(displayEntries[:1], tt.format, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+		want    string
+	}{
+		{
+			"aligned columns",
+			displayEntries,
+			"SiteID  FixletID  Name           Criticality  RelevantComputerCount\n" +
+				"------  --------  -------------  -----------  ---------------------\n" +
+				"1       101       Kernel Update  Critical     120\n" +
+				"2       102       Driver Pack    Low          7\n",
+		},
+		{
+			"wide values",
+			[]Entry{{123456789, 1, "Größe", "Informational", 1, ""}, {1, 2, "A", "Low", 0, ""}},
+			"SiteID     FixletID  Name   Criticality    RelevantComputerCount\n" +
+				"---------  --------  -----  -------------  ---------------------\n" +
+				"123456789  1         Größe  Informational  1\n" +
+				"1          2         A      Low            0\n",
+		},
+		{
+			"no entries",
+			nil,
+			"SiteID  FixletID  Name  Criticality  RelevantComputerCount\n" +
+				"------  --------  ----  -----------  ---------------------\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			PrintTable(tt.entries, &buf)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("PrintTable output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpsertEntryKeepsNotes(t *testing.T) {
+	entries := []Entry{{1, 2, "Update", "High", 5, "hi"}}
+	updated, result, err := UpsertEntry(slices.Clone(entries), Entry{1, 2, "Update", "Low", 5, ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Entry{1, 2, "Update", "Low", 5, "hi"}); !result.Updated || updated[0] != want {
+		t.Errorf("UpsertEntry() = %v, %+v; want %v updated", updated, result, want)
+	}
+	updated, _, err = UpsertEntry(slices.Clone(entries), Entry{1, 2, "Update", "High", 5, "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated[0].Notes != "new" {
+		t.Errorf("Notes = %q, want the upserted ones", updated[0].Notes)
+	}
+	inserted, result, err := UpsertEntry(slices.Clone(entries), Entry{1, 3, "Patch", "High", 1, "fresh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Inserted || len(inserted) != 2 || inserted[1].Notes != "fresh" {
+		t.Errorf("UpsertEntry() = %v, %+v; want the entry inserted with its notes", inserted, result)
+	}
+}
+
+func TestAppendCSVNotes(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.csv")
+	if err := WriteCSV(plain, []Entry{{1, 2, "Update", "High", 5, ""}}, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(plain)
+	if err := AppendCSV(plain, []Entry{{1, 3, "Patch", "Low", 1, "hi"}}, CSVOptions{}); err == nil {
+		t.Error("AppendCSV() with notes onto a header without Notes succeeded")
+	}
+	if after, _ := os.ReadFile(plain); !bytes.Equal(after, before) {
+		t.Errorf("file changed after a refused append:\n%s", after)
+	}
+	if err := AppendCSV(plain, []Entry{{1, 3, "Patch", "Low", 1, ""}}, CSVOptions{}); err != nil {
+		t.Errorf("AppendCSV() without notes: %v", err)
+	}
+
+	noted := filepath.Join(dir, "noted.csv")
+	if err := WriteCSV(noted, []Entry{{1, 2, "Update", "High", 5, "old"}}, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendCSV(noted, []Entry{{1, 3, "Patch", "Low", 1, "hi"}}, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, _, err := ReadCSV(noted, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{{1, 2, "Update", "High", 5, "old"}, {1, 3, "Patch", "Low", 1, "hi"}}
+	if !slices.Equal(entries, want) {
+		t.Errorf("ReadCSV() = %v, want %v", entries, want)
+	}
+}
+
+func TestUpdateEntryKeepsNotes(t *testing.T) {
+	entries := []Entry{{1, 2, "Update", "High", 5, "hi"}}
+	updated, diff, found, err := UpdateEntry(entries, 2, Entry{1, 2, "Update", "Low", 5, ""})
+	if err != nil || !found {
+		t.Fatalf("UpdateEntry() = %v, %v", found, err)
+	}
+	if updated[0].Notes != "hi" || len(diff) != 1 {
+		t.Errorf("UpdateEntry() = %v, diff %v; want the notes kept and only Criticality changed", updated, diff)
+	}
+}
+
+func TestCompactCSV(t *testing.T) {
+	header := "SiteID,FixletID,Name,Criticality,RelevantComputerCount\n"
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		removed int
+	}{
+		{"clean", header + "1,2,A,High,5\n", header + "1,2,A,High,5\n", 0},
+		{"empty lines", header + "\n1,2,A,High,5\n\n\n", header + "1,2,A,High,5\n", 3},
+		{"whitespace record", header + " , ,,, \n1,2,A,High,5\n", header + "1,2,A,High,5\n", 1},
+		{"no final newline", header + "1,2,A,High,5\n\n1,3,B,Low,1", header + "1,2,A,High,5\n1,3,B,Low,1\n", 1},
+		{"quoted newline", header + "1,2,\"A\nB\",High,5\n\n1,3,C,Low,1\n", header + "1,2,\"A\nB\",High,5\n1,3,C,Low,1\n", 1},
+		{"trimmed fields", header + " 1 , 2 ,A , High,5\n", header + "1,2,A,High,5\n", 0},
+		{"last modified", "# last_modified: 2026-01-02T03:04:05Z\n" + header + "\n1,2,A,High,5\n", "# last_modified: 2026-01-02T03:04:05Z\n" + header + "1,2,A,High,5\n", 1},
+		{"crlf", strings.ReplaceAll(header+"1,2,A,High,5\n\n", "\n", "\r\n"), header + "1,2,A,High,5\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			removed, err := compactCSV(tt.content, &buf, CSVOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want || removed != tt.removed {
+				t.Errorf("compactCSV() = %q, %d; want %q, %d", buf.String(), removed, tt.want, tt.removed)
+			}
+		})
+	}
+}
+
+func TestSessionAuditOnSave(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "fixlets.csv")
+	entries := []Entry{{1, 2, "Update", "High", 5, ""}, {1, 3, "Patch", "Low", 1, ""}}
+	if err := WriteCSV(filename, entries, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	run := func(script string) {
+		t.Helper()
+		path := filepath.Join(dir, "script.txt")
+		if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		loaded, _, _, err := ReadCSV(filename, CSVOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := RunScript(path, loaded, Options{File: filename}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("delete\n2\nreload\ny\n")
+	if events, err := ReadAuditLog(AuditLogPath(filename), 10); err != nil || len(events) != 0 {
+		t.Errorf("audit log after discarded changes = %v, %v; want no events", events, err)
+	}
+	run("delete\n2\n")
+	events, err := ReadAuditLog(AuditLogPath(filename), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Operation != "delete" || events[0].FxiletID != 2 {
+		t.Errorf("audit log after saving = %+v, want one delete of FixletID 2", events)
+	}
+}
+
+func TestChangeEventsDuplicates(t *testing.T) {
+	before := []Entry{{1, 2, "Update", "High", 5, ""}, {1, 2, "Copy", "Low", 1, ""}, {1, 3, "Patch", "Low", 1, ""}, {1, 2, "Copy", "Low", 1, ""}}
+	after, removed := DeduplicateEntries(slices.Clone(before), KeepFirst)
+	if removed != 2 {
+		t.Fatalf("DeduplicateEntries() removed %d, want 2", removed)
+	}
+	events := changeEvents("delete", before, after)
+	if len(events) != 2 {
+		t.Fatalf("changeEvents() = %+v, want two deletes", events)
+	}
+	for _, ev := range events {
+		if ev.FxiletID != 2 || ev.After != nil || ev.Before == nil || *ev.Before != before[1] {
+			t.Errorf("event = %+v, want the delete of %v", ev, before[1])
+		}
+	}
+	if events := changeEvents("add", after, before); len(events) != 2 || events[0].After == nil {
+		t.Errorf("changeEvents() of added copies = %+v, want two additions", events)
+	}
+}
+
+func TestCriticalityFilename(t *testing.T) {
+	tests := []struct{ level, want string }{
+		{"High", "high.csv"},
+		{"Very High", "very_high.csv"},
+		{"../../etc/passwd", "______etc_passwd.csv"},
+		{`C:\evil`, "c__evil.csv"},
+		{"non-critical_2", "non-critical_2.csv"},
+	}
+	for _, tt := range tests {
+		if got := criticalityFilename(tt.level); got != tt.want {
+			t.Errorf("criticalityFilename(%q) = %q, want %q", tt.level, got, tt.want)
+		}
+		if got := criticalityFilename(tt.level); filepath.Base(got) != got {
+			t.Errorf("criticalityFilename(%q) = %q leaves its directory", tt.level, got)
+		}
+	}
+}
+
+func TestAppendCSVLastModified(t *testing.T) {
+	const old = "# last_modified: 2001-02-03T04:05:06Z\n"
+	const rows = "SiteID,FixletID,Name,Criticality,RelevantComputerCount\n1,2,Update,High,5\n"
+	tests := []struct {
+		name    string
+		file    string
+		content string // written before appending; empty for no file
+	}{
+		{"new file", "fixlets.csv", ""},
+		{"stamped", "fixlets.csv", old + rows},
+		{"not stamped", "fixlets.csv", rows},
+		{"no final newline", "fixlets.csv", strings.TrimSuffix(rows, "\n")},
+		{"gzip", "fixlets.csv.gz", old + rows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tt.file)
+			var want []Entry
+			if tt.content != "" {
+				if err := writeFileCompressed(filename, func(w io.Writer) error {
+					_, err := io.WriteString(w, tt.content)
+					return err
+				}); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, Entry{1, 2, "Update", "High", 5, ""})
+			}
+			added := Entry{1, 3, "Patch", "Low", 1, ""}
+			start := time.Now().Truncate(time.Second)
+			if err := AppendCSV(filename, []Entry{added}, CSVOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			entries, modified, rowErrors, err := ReadCSV(filename, CSVOptions{})
+			if err != nil || len(rowErrors) != 0 {
+				t.Fatalf("ReadCSV() = %v, %v", rowErrors, err)
+			}
+			if want = append(want, added); !slices.Equal(entries, want) {
+				t.Errorf("ReadCSV() = %v, want %v", entries, want)
+			}
+			if modified.Before(start) {
+				t.Errorf("last_modified = %v, want at least %v", modified, start)
+			}
+		})
+	}
+}
+
+func TestPreviewSaveIncludesLastModified(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fixlets.csv")
+	entries := []Entry{{1, 2, "Update", "High", 5, ""}}
+	if err := WriteCSV(filename, entries, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{File: filename}
+	out := captureStdout(t, func() {
+		if err := previewSave(entries, opts); err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(out, "no changes") {
+		t.Errorf("preview of unchanged entries = %q, want no changes", out)
+	}
+	out = captureStdout(t, func() {
+		if err := previewSave(append(entries, Entry{1, 3, "Patch", "Low", 1, ""}), opts); err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(out, lastModifiedPrefix) || !strings.Contains(out, "+1,3,Patch,Low,1") {
+		t.Errorf("preview = %q, want the new last_modified comment and row", out)
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	os.Stdout = saved
+	w.Close()
+	return <-done
+}
+
+func TestLockFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fixlets.csv")
+	lock, err := LockFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A save made while the lock is held does not wait for it.
+	if err := WriteCSV(filename, []Entry{{1, 2, "Update", "High", 5, ""}}, CSVOptions{}); err != nil {
+		t.Fatalf("WriteCSV() while locked: %v", err)
+	}
+	if _, err := os.Stat(filename + ".lock"); err != nil {
+		t.Errorf("lock file gone while still locked: %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file left after Unlock: %v", err)
+	}
+	if err := lock.Unlock(); err == nil {
+		t.Error("second Unlock() succeeded")
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.csv")
+	incoming := []Entry{{1, 2, "Update", "High", 5, ""}, {1, 3, "Changed", "Low", 1, ""}, {1, 4, "New", "Low", 1, ""}}
+	if err := WriteCSV(src, incoming, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dest := []Entry{{1, 2, "Update", "High", 5, ""}, {1, 3, "Patch", "Low", 1, ""}}
+	tests := []struct {
+		strategy MergeStrategy
+		want     []Entry
+		report   ImportReport
+	}{
+		{MergeSkip, []Entry{dest[0], dest[1], incoming[2]}, ImportReport{Added: 1, Skipped: 2, Conflicted: 1}},
+		{MergeOverwrite, []Entry{dest[0], incoming[1], incoming[2]}, ImportReport{Added: 1, Skipped: 1, Overwritten: 1, Conflicted: 1}},
+	}
+	for _, tt := range tests {
+		merged, report, err := ImportCSV(slices.Clone(dest), src, tt.strategy, CSVOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(merged, tt.want) || len(report.RowErrors) != 0 || report.Added != tt.report.Added ||
+			report.Skipped != tt.report.Skipped || report.Overwritten != tt.report.Overwritten || report.Conflicted != tt.report.Conflicted {
+			t.Errorf("ImportCSV(%v) = %v, %+v; want %v, %+v", tt.strategy, merged, report, tt.want, tt.report)
+		}
+	}
+	merged, _, err := ImportCSV(slices.Clone(dest), src, MergeFail, CSVOptions{})
+	if !errors.Is(err, ErrDuplicateFxiletID) || !strings.HasSuffix(err.Error(), ": 3") {
+		t.Errorf("ImportCSV(MergeFail) error = %v, want the conflict on FixletID 3", err)
+	}
+	if !slices.Equal(merged, dest) {
+		t.Errorf("ImportCSV(MergeFail) = %v, want dest unchanged", merged)
+	}
+}
+
+func TestStreamCSVRowErrors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fixlets.csv")
+	content := "SiteID,FixletID,Name,Criticality,RelevantComputerCount\n1,2,Update,High,5\nx,3,Bad,Low,1\n1,4,Odd,Urgent,1\n"
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	var lines []int
+	err := StreamCSV(filename, CSVOptions{}, func(e Entry) error {
+		ids = append(ids, e.FixletID)
+		return nil
+	}, func(rowErr RowError) {
+		lines = append(lines, rowErr.Line)
+	})
+	if err != nil {
+		t.Fatalf("StreamCSV() = %v, want no error for problematic rows", err)
+	}
+	if !slices.Equal(ids, []int{2, 4}) || !slices.Equal(lines, []int{3, 4}) {
+		t.Errorf("StreamCSV() streamed %v and reported lines %v; want [2 4] and [3 4]", ids, lines)
+	}
+	var buf bytes.Buffer
+	n, err := StreamFilter(filename, CSVOptions{}, func(e Entry) bool { return e.FixletID == 2 }, nil, &buf)
+	if err != nil || n != 1 {
+		t.Errorf("StreamFilter() = %d, %v; want 1 match", n, err)
+	}
+}
+
+func TestImportCSVRowErrors(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.csv")
+	content := "SiteID,FixletID,Name,Criticality,RelevantComputerCount\n1,2,Update,High,5\n1,x,Bad,Low,1\n"
+	if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	merged, report, err := ImportCSV(nil, src, MergeSkip, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 1 || report.Added != 1 {
+		t.Errorf("ImportCSV() = %v, %+v; want the one good row added", merged, report)
+	}
+	if len(report.RowErrors) != 1 || report.RowErrors[0].Line != 3 || report.RowErrors[0].File != src {
+		t.Errorf("RowErrors = %v, want line 3 of %s", report.RowErrors, src)
+	}
+}
+
+func TestParseResolver(t *testing.T) {
+	a, b := Entry{1, 2, "Update", "High", 5, ""}, Entry{1, 2, "Copy", "High", 9, ""}
+	for name, want := range map[string]Entry{"first": a, "higher-computers": b, "Higher-Computers": b} {
+		resolve, err := ParseResolver(name)
+		if err != nil {
+			t.Fatalf("ParseResolver(%q): %v", name, err)
+		}
+		if got := resolve(a, b); got != want {
+			t.Errorf("ParseResolver(%q) kept %v, want %v", name, got, want)
+		}
+	}
+	if _, err := ParseResolver("last"); err == nil {
+		t.Error(`ParseResolver("last") succeeded`)
+	}
+}
+
+func TestQueryHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query_history")
+	for _, term := range []string{"Update", "Update", "Copy", "Update"} {
+		if err := AppendQueryHistory(path, term); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := LoadQueryHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := h.Items(), []string{"Update", "Copy", "Update"}; !slices.Equal(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+	for term, want := range map[string]string{"!2": "Copy", "!!2": "!2", "!!": "!", "Copy": "Copy"} {
+		if got, err := h.Recall(term); err != nil || got != want {
+			t.Errorf("Recall(%q) = %q, %v, want %q", term, got, err, want)
+		}
+	}
+	if _, err := h.Recall("!4"); err == nil {
+		t.Error(`Recall("!4") succeeded`)
+	}
+}
+
+func TestImportJSONValidates(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"site":        `[{"SiteID":0,"FixletID":1,"Name":"a","Criticality":"High"}]`,
+		"criticality": `[{"SiteID":1,"FixletID":1,"Name":"a","Criticality":"Bogus"}]`,
+		"duplicate":   `[{"SiteID":1,"FixletID":1,"Name":"a","Criticality":"High"},{"SiteID":1,"FixletID":1,"Name":"b","Criticality":"Low"}]`,
+	} {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ImportJSON(path); err == nil {
+			t.Errorf("ImportJSON accepted an invalid %s", name)
+		}
+	}
+}
+
+func TestPatchEntryRejects(t *testing.T) {
+	entries := []Entry{{1, 1, "a", "High", 3, ""}, {1, 2, "b", "Low", 4, ""}}
+	if _, _, _, err := PatchEntry(slices.Clone(entries), 1, Entry{RelevantComputerCount: -5}); err == nil {
+		t.Error("PatchEntry accepted a negative RelevantComputerCount")
+	}
+	if _, _, _, err := PatchEntry(slices.Clone(entries), 1, Entry{FixletID: 2}); !errors.Is(err, ErrDuplicateFxiletID) {
+		t.Errorf("PatchEntry to a used FixletID: err = %v, want ErrDuplicateFxiletID", err)
+	}
+	got, _, found, err := PatchEntry(slices.Clone(entries), 1, Entry{FixletID: 3})
+	if err != nil || !found || got[0].FixletID != 3 {
+		t.Errorf("PatchEntry to a free FixletID = %v, %v, %v", got, found, err)
+	}
+}
+
+func TestReadCSVLastModified(t *testing.T) {
+	for _, name := range []string{"fixlets.csv", "fixlets.csv.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := WriteCSV(path, []Entry{{1, 1, "a", "High", 3, ""}}, CSVOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		entries, modified, _, err := ReadCSV(path, CSVOptions{})
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("ReadCSV(%s) = %v, %v", name, entries, err)
+		}
+		if want, _ := ReadLastModified(path); modified.IsZero() || !modified.Equal(want) {
+			t.Errorf("ReadCSV(%s) last_modified = %v, want %v", name, modified, want)
+		}
+	}
+}
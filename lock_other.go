@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// tryLock always succeeds on platforms without advisory locks.
+func tryLock(file *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlock is a no-op on platforms without advisory locks.
+func unlock(file *os.File) error {
+	return nil
+}